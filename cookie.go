@@ -0,0 +1,134 @@
+package secure
+
+import (
+	"fmt"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCookieName = "session"
+	hostPrefix        = "__Host-"
+	securePrefix      = "__Secure-"
+)
+
+/*
+A Cookie value is stored in the Session to set the attributes of the session
+cookie. The cookie is always Secure.
+*/
+type Cookie struct {
+
+	// Name is the name of the cookie. It gets the "__Host-" prefix if Domain
+	// is empty and Path is "/", or else the "__Secure-" prefix, so that the
+	// browser enforces the attributes. It must be a valid cookie name; see
+	// ErrCookieName.
+	// Default value is "session".
+	Name string
+
+	// Domain has the browser send the cookie to subdomains as well.
+	// Default value is "", for the current host only.
+	Domain string
+
+	// Path limits the URLs that the browser sends the cookie to.
+	// Default value is "/".
+	Path string
+
+	// SameSite controls sending the cookie with cross site requests.
+	// Default value is http.SameSiteLaxMode.
+	SameSite http.SameSite
+
+	// AllowScripts drops the HttpOnly attribute, so that JavaScript can read
+	// the cookie.
+	// Default value is false.
+	AllowScripts bool
+
+	// Partitioned has the browser keep a separate cookie per top level site
+	// (CHIPS), for embedding the application in other sites.
+	// Default value is false.
+	Partitioned bool
+}
+
+func (c *Cookie) path() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return "/"
+}
+
+// name returns the cookie name, with the strongest prefix that the attributes
+// allow.
+func (c *Cookie) name() string {
+	name := c.Name
+	if name == "" {
+		name = defaultCookieName
+	}
+	name = strings.TrimPrefix(strings.TrimPrefix(name, hostPrefix), securePrefix)
+	if c.Domain == "" && c.path() == "/" {
+		return hostPrefix + name
+	}
+	return securePrefix + name
+}
+
+// validate returns ErrCookieName if the cookie name isn't a valid token.
+func (c *Cookie) validate() error {
+	cookie := &http.Cookie{Name: c.name(), Value: "x"}
+	if cookie.Valid() != nil {
+		return fmt.Errorf("%w: %q", ErrCookieName, c.Name)
+	}
+	return nil
+}
+
+func (c *Cookie) sameSite() http.SameSite {
+	if c.SameSite != 0 {
+		return c.SameSite
+	}
+	return http.SameSiteLaxMode
+}
+
+// isSessionCookie reports whether the cookie is the session cookie, or one of
+// its chunks.
+func isSessionCookie(cookieName, sessionName string) bool {
+	if cookieName == sessionName {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(cookieName, sessionName+"."); ok {
+		_, err := strconv.Atoi(rest)
+		return err == nil
+	}
+	return false
+}
+
+func (s *Session) cookieName() string {
+	return s.Cookie.name()
+}
+
+// rememberCookieName returns the name of the remember-me cookie, derived from
+// the session cookie's name, so that Managers with different cookies don't
+// share it.
+func (s *Session) rememberCookieName() string {
+	return s.Cookie.name() + "-remember"
+}
+
+func (s *Session) options() *sessions.Options {
+	return &sessions.Options{
+		MaxAge:   int(s.absoluteTimeOut() / time.Second),
+		Secure:   true,
+		HttpOnly: !s.Cookie.AllowScripts,
+		Domain:   s.Cookie.Domain,
+		Path:     s.Cookie.path(),
+	}
+}
+
+// attributes sets the attributes that sessions.Options doesn't have, and
+// makes it a browser session cookie, i.e. without Max-Age and Expires, unless
+// it's being deleted.
+func (s *Session) attributes(cookie *http.Cookie) {
+	if cookie.MaxAge > 0 {
+		cookie.MaxAge, cookie.Expires, cookie.RawExpires = 0, time.Time{}, ""
+	}
+	cookie.SameSite = s.Cookie.sameSite()
+	cookie.Partitioned = s.Cookie.Partitioned
+}
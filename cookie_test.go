@@ -0,0 +1,129 @@
+package secure
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"testing"
+)
+
+func TestCookieName(t *testing.T) {
+	tests := []struct {
+		cookie Cookie
+		want   string
+	}{
+		{Cookie{}, "__Host-session"},
+		{Cookie{Name: "app"}, "__Host-app"},
+		{Cookie{Name: "__Host-app"}, "__Host-app"},
+		{Cookie{Name: "__Secure-app"}, "__Host-app"},
+		{Cookie{Name: "app", Path: "/app"}, "__Secure-app"},
+		{Cookie{Name: "__Host-app", Domain: "example.com"}, "__Secure-app"},
+	}
+	for _, test := range tests {
+		if got := test.cookie.name(); got != test.want {
+			t.Errorf("%+v: got %s; want %s", test.cookie, got, test.want)
+		}
+	}
+}
+
+// sessionCookie returns the session cookie that the response sets.
+func sessionCookie(t *testing.T, m *Manager, header http.Header) *http.Cookie {
+	t.Helper()
+	for _, line := range header["Set-Cookie"] {
+		if cookie, err := http.ParseSetCookie(line); err == nil && cookie.Name == m.session().cookieName() {
+			return cookie
+		}
+	}
+	t.Fatalf("no session cookie in %v", header["Set-Cookie"])
+	return nil
+}
+
+func TestCookieAttributes(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Cookie = Cookie{
+			Name:         "app",
+			Domain:       "example.com",
+			Path:         "/app",
+			SameSite:     http.SameSiteStrictMode,
+			AllowScripts: true,
+			Partitioned:  true,
+		}
+	})
+	c := newClient()
+	check := func(cookie *http.Cookie) {
+		t.Helper()
+		if cookie.Name != "__Secure-app" || cookie.Domain != "example.com" || cookie.Path != "/app" ||
+			cookie.SameSite != http.SameSiteStrictMode || cookie.HttpOnly || !cookie.Secure || !cookie.Partitioned {
+			t.Errorf("got %v", cookie)
+		}
+	}
+	check(sessionCookie(t, m, c.logIn(t, m, testRecord{"alice"}).Header()))
+	w := c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.LogOut(w, r, false)
+	}), newRequest("POST", "/session", nil))
+	deleted := sessionCookie(t, m, w.Header())
+	check(deleted)
+	if deleted.MaxAge >= 0 {
+		t.Errorf("got Max-Age %d; want the cookie deleted", deleted.MaxAge)
+	}
+}
+
+func TestCookieDefaults(t *testing.T) {
+	m := newTestManager(t)
+	cookie := sessionCookie(t, m, newClient().logIn(t, m, testRecord{"alice"}).Header())
+	if cookie.Name != "__Host-session" || cookie.Path != "/" || cookie.Domain != "" ||
+		cookie.SameSite != http.SameSiteLaxMode || !cookie.HttpOnly || !cookie.Secure || cookie.Partitioned {
+		t.Errorf("got %v", cookie)
+	}
+}
+
+func TestCookieNameInvalid(t *testing.T) {
+	config := defaultConfig()
+	config.Session.Cookie.Name = "my session"
+	if _, err := New(context.Background(), testRecord{}, &memDB{}, validRecord, config); !errors.Is(err, ErrCookieName) {
+		t.Errorf("New: got %v; want ErrCookieName", err)
+	}
+
+	m := newTestManager(t)
+	m.Stop()
+	config = defaultConfig()
+	config.Session.Cookie.Name = "my session"
+	if err := m.db.Upsert(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(); !errors.Is(err, ErrSync) || !errors.Is(err, ErrCookieName) {
+		t.Errorf("sync: got %v; want ErrSync and ErrCookieName", err)
+	}
+	if name := m.session().cookieName(); name != "__Host-session" {
+		t.Errorf("got cookie name %q; want the valid one kept", name)
+	}
+}
+
+// nilStore is a sessions.Store that fails to create sessions.
+type nilStore struct{}
+
+func (nilStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return nil, errNoConfig
+}
+
+func (nilStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return nil, errNoConfig
+}
+
+func (nilStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	return errNoConfig
+}
+
+func TestNilSession(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Logger = new(testLogger)
+		config.Session.Store = func(codecs []securecookie.Codec) sessions.Store {
+			return nilStore{}
+		}
+	})
+	if _, ok := newClient().authentication(m); ok {
+		t.Error("authenticated without a session")
+	}
+}
@@ -0,0 +1,100 @@
+package secure
+
+import (
+	"github.com/gorilla/sessions"
+	"sync"
+	"time"
+)
+
+/*
+A Denylist holds the IDs of the sessions that have been logged out, until they
+would have expired anyway. Implement it on a shared store when running multiple
+servers.
+*/
+type Denylist interface {
+
+	// Add denies the session ID, until it expires.
+	Add(id string, expires time.Time) error
+
+	// Contains reports whether the session ID is denied.
+	Contains(id string) (denied bool, err error)
+}
+
+type memoryDenylist struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	purged  time.Time
+}
+
+/*
+NewMemoryDenylist returns a Denylist that keeps the IDs in memory. It's the
+default for Session.Denylist.
+*/
+func NewMemoryDenylist() Denylist {
+	return &memoryDenylist{entries: make(map[string]time.Time)}
+}
+
+func (d *memoryDenylist) Add(id string, expires time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[id] = expires
+	d.purge()
+	return nil
+}
+
+func (d *memoryDenylist) Contains(id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	expires, ok := d.entries[id]
+	return ok && time.Now().Before(expires), nil
+}
+
+// purge drops the expired entries, at most once a minute.
+func (d *memoryDenylist) purge() {
+	now := time.Now()
+	if now.Sub(d.purged) < time.Minute {
+		return
+	}
+	d.purged = now
+	for id, expires := range d.entries {
+		if !now.Before(expires) {
+			delete(d.entries, id)
+		}
+	}
+}
+
+func (m *Manager) denylist() Denylist {
+	if denylist := m.session().Denylist; denylist != nil {
+		return denylist
+	}
+	return m.denied
+}
+
+// deny adds the session's ID to the Denylist, until the session would have
+// timed out.
+func (m *Manager) deny(session *sessions.Session) error {
+	id, ok := session.Values[idField].(string)
+	if !ok {
+		return nil
+	}
+	timeOut := m.session().absoluteTimeOut()
+	expires := time.Now().Add(timeOut)
+	if created, ok := session.Values[createdField].(time.Time); ok {
+		expires = created.Add(timeOut)
+	}
+	return m.denylist().Add(id, expires)
+}
+
+// allowed reports whether the session's ID isn't on the Denylist.
+func (m *Manager) allowed(session *sessions.Session) bool {
+	id, ok := session.Values[idField].(string)
+	if !ok {
+		return true
+	}
+	denied, err := m.denylist().Contains(id)
+	if err != nil {
+		m.logger().Error("secure: checking session denylist failed", "error", err)
+		return false
+	}
+	return !denied
+}
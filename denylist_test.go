@@ -0,0 +1,37 @@
+package secure
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryDenylist(t *testing.T) {
+	denylist := NewMemoryDenylist()
+	if err := denylist.Add("a", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := denylist.Add("b", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for id, want := range map[string]bool{"a": true, "b": false, "c": false} {
+		if denied, err := denylist.Contains(id); err != nil || denied != want {
+			t.Errorf("Contains(%s) = %v, %v; want %v", id, denied, err, want)
+		}
+	}
+}
+
+func TestLogOutDeniesCopies(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	stolen := c.copyCookies()
+	other := newClient()
+	other.logIn(t, m, testRecord{"alice"})
+	c.logOut(m)
+	if _, ok := stolen.authentication(m); ok {
+		t.Error("copied cookie accepted after LogOut")
+	}
+	if _, ok := other.authentication(m); !ok {
+		t.Error("other session of the user rejected")
+	}
+}
@@ -0,0 +1,134 @@
+package secure
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// CSRFMode selects how a SecureRouter protects PUT, POST, PATCH, and DELETE
+// requests against cross site request forgery.
+type CSRFMode int
+
+const (
+
+	// FormTokenMode requires an encrypted FormToken, bound to the client's IP
+	// address and the path.
+	FormTokenMode CSRFMode = iota
+
+	// DoubleSubmitMode implements the signed double submit cookie pattern: a
+	// cookie holds a random secret, and the request must carry the token
+	// that DoubleSubmitToken() derives from the secret and the client's
+	// session. The token doesn't depend on the page or time, so it works for
+	// cached pages and multiple tabs.
+	DoubleSubmitMode
+)
+
+// doubleSubmitCookie is the name of the cookie holding the secret. The
+// __Host- prefix has the browser require Secure, Path=/, and no Domain.
+const doubleSubmitCookie = "__Host-csrf"
+
+// doubleSubmitSecret returns the secret from the request's cookie, or sets a
+// new one.
+func doubleSubmitSecret(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(doubleSubmitCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	cookie := &http.Cookie{
+		Name:     doubleSubmitCookie,
+		Value:    newID(),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, cookie)
+	// Have any later call for this request use the same secret
+	r.AddCookie(cookie)
+	return cookie.Value
+}
+
+// doubleSubmitMAC signs the secret and the session ID with a key derived from
+// the authentication key of the given key pair, so that the key isn't shared
+// with the session cookies. The session keys are used, rather than the token
+// keys, since they're rotated much less frequently.
+func (s *Session) doubleSubmitMAC(pair int, sessionID, secret string) []byte {
+	key := hmac.New(sha256.New, s.KeyPairs[2*pair])
+	key.Write([]byte("csrf"))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(sessionID + "\x00" + secret))
+	return mac.Sum(nil)
+}
+
+/*
+DoubleSubmitToken returns the token to include in forms and AJAX requests for a
+SecureRouter in DoubleSubmitMode. It sets the secret cookie if the client
+doesn't have one yet, so call it before writing the response body.
+
+The token is bound to the client's session; it changes on LogIn() and LogOut().
+*/
+func (m *Manager) DoubleSubmitToken(w http.ResponseWriter, r *http.Request) string {
+	secret := doubleSubmitSecret(w, r)
+	mac := m.session().doubleSubmitMAC(0, m.sessionID(r), secret)
+	return base64.RawURLEncoding.EncodeToString(mac)
+}
+
+/*
+DoubleSubmitToken calls DoubleSubmitToken on the default Manager.
+*/
+func DoubleSubmitToken(w http.ResponseWriter, r *http.Request) string {
+	return manager.DoubleSubmitToken(w, r)
+}
+
+// doubleSubmitValid reports whether the token matches the request's secret
+// cookie and session, with the current or the previous session key pair. The
+// next key pair isn't in use yet.
+func (m *Manager) doubleSubmitValid(r *http.Request, token string) bool {
+	cookie, err := r.Cookie(doubleSubmitCookie)
+	if err != nil {
+		return false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return false
+	}
+	session, sessionID := m.session(), m.sessionID(r)
+	for pair := 0; pair < 2; pair++ {
+		if hmac.Equal(mac, session.doubleSubmitMAC(pair, sessionID, cookie.Value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyDoubleSubmit reports whether the request carries a valid
+// DoubleSubmitToken. If not, it writes the response through
+// Config.TokenInvalid.
+func (m *Manager) verifyDoubleSubmit(w http.ResponseWriter, r *http.Request) bool {
+	if m.doubleSubmitValid(r, formTokenValue(r, m.token().headerName())) {
+		return true
+	}
+	m.logger().Warn("Double submit token invalid", "ip", m.token().clientIP(r), "path", r.URL.Path)
+	m.event(Event{Type: EventFormTokenRejected, Request: r, Reason: "mismatch"})
+	m.tokenInvalid(w, r, "CSRF token validation failed", "mismatch", nil, nil)
+	return false
+}
+
+/*
+DoubleSubmitTokenHandle serves the token for DoubleSubmitMode to JavaScript
+clients, as JSON: {"_formtoken": "..."}. The token is also set in the response
+header named by Token.HeaderName.
+*/
+func (m *Manager) DoubleSubmitTokenHandle(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	m = m.orDefault()
+	writeToken(w, m.token().headerName(), m.DoubleSubmitToken(w, r))
+}
+
+/*
+DoubleSubmitTokenHandle calls DoubleSubmitTokenHandle on the default Manager.
+*/
+func DoubleSubmitTokenHandle(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	(*Manager)(nil).DoubleSubmitTokenHandle(w, r, ps)
+}
@@ -0,0 +1,73 @@
+package secure
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+// doubleSubmitToken returns the client's DoubleSubmitToken, setting the secret
+// cookie.
+func (c *client) doubleSubmitToken(m *Manager) (token string) {
+	c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = m.DoubleSubmitToken(w, r)
+	}), newRequest("GET", "/", nil))
+	return
+}
+
+// postDoubleSubmit posts the token through VerifyDoubleSubmit, and returns the
+// status code.
+func (c *client) postDoubleSubmit(m *Manager, token string) int {
+	r := newRequest("POST", "/form", nil)
+	r.Header.Set(defaultHeaderName, token)
+	return c.do(m.VerifyDoubleSubmit(okHandler), r).Code
+}
+
+func TestDoubleSubmit(t *testing.T) {
+	m := newTestManager(t)
+	alice, mallory := newClient(), newClient()
+	token := alice.doubleSubmitToken(m)
+	if _, ok := alice.cookies[doubleSubmitCookie]; !ok {
+		t.Fatal("secret cookie not set")
+	}
+	if again := alice.doubleSubmitToken(m); again != token {
+		t.Error("token changed within the session")
+	}
+	if code := alice.postDoubleSubmit(m, token); code != http.StatusOK {
+		t.Errorf("valid token: got %d; want 200", code)
+	}
+	mallory.doubleSubmitToken(m)
+	if code := mallory.postDoubleSubmit(m, token); code != http.StatusBadRequest {
+		t.Errorf("other secret: got %d; want 400", code)
+	}
+	if code := newClient().postDoubleSubmit(m, token); code != http.StatusBadRequest {
+		t.Errorf("no secret: got %d; want 400", code)
+	}
+	alice.logIn(t, m, testRecord{"alice"})
+	if code := alice.postDoubleSubmit(m, token); code != http.StatusBadRequest {
+		t.Errorf("other session: got %d; want 400", code)
+	}
+}
+
+func TestDoubleSubmitRotation(t *testing.T) {
+	m := newTestManager(t)
+	m.Stop()
+	c := newClient()
+	token := c.doubleSubmitToken(m)
+	secret := c.cookies[doubleSubmitCookie].Value
+	next := base64.RawURLEncoding.EncodeToString(m.session().doubleSubmitMAC(2, "", secret))
+	if code := c.postDoubleSubmit(m, next); code != http.StatusBadRequest {
+		t.Errorf("next key pair: got %d; want 400", code)
+	}
+	rotate(t, m)
+	if code := c.postDoubleSubmit(m, token); code != http.StatusOK {
+		t.Errorf("after one rotation: got %d; want 200", code)
+	}
+	if code := c.postDoubleSubmit(m, next); code != http.StatusOK {
+		t.Errorf("next key pair after rotation: got %d; want 200", code)
+	}
+	rotate(t, m)
+	if code := c.postDoubleSubmit(m, token); code != http.StatusBadRequest {
+		t.Errorf("after two rotations: got %d; want 400", code)
+	}
+}
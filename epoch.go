@@ -0,0 +1,113 @@
+package secure
+
+import (
+	"github.com/gorilla/sessions"
+	"sync"
+)
+
+/*
+An EpochSource keeps a generation number (epoch) per user. Each session stores
+the user's epoch at LogIn(); RevokeAll() bumps it, which rejects all sessions
+with an older epoch. Implement it on the application's database to share the
+epochs between servers.
+*/
+type EpochSource interface {
+
+	// Epoch returns the user's current epoch.
+	Epoch(userKey string) (epoch uint64, err error)
+
+	// Bump increments the user's epoch.
+	Bump(userKey string) error
+}
+
+type memoryEpochSource struct {
+	mu     sync.Mutex
+	epochs map[string]uint64
+}
+
+/*
+NewMemoryEpochSource returns an EpochSource that keeps the epochs in memory. It's
+the default for Session.Epochs.
+*/
+func NewMemoryEpochSource() EpochSource {
+	return &memoryEpochSource{epochs: make(map[string]uint64)}
+}
+
+func (s *memoryEpochSource) Epoch(userKey string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.epochs[userKey], nil
+}
+
+func (s *memoryEpochSource) Bump(userKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.epochs[userKey]++
+	return nil
+}
+
+func (m *Manager) epochSource() EpochSource {
+	if epochs := m.session().Epochs; epochs != nil {
+		return epochs
+	}
+	return m.epochs
+}
+
+// userKey returns the key that identifies the user of the record, or "" if
+// Session.UserKey isn't set.
+func (m *Manager) userKey(record interface{}) string {
+	if userKey := m.session().UserKey; userKey != nil {
+		return userKey(record)
+	}
+	return ""
+}
+
+// setEpoch stores the user's current epoch in the session.
+func (m *Manager) setEpoch(session *sessions.Session, record interface{}) error {
+	userKey := m.userKey(record)
+	if userKey == "" {
+		delete(session.Values, userField)
+		delete(session.Values, epochField)
+		return nil
+	}
+	epoch, err := m.epochSource().Epoch(userKey)
+	if err != nil {
+		return err
+	}
+	session.Values[userField] = userKey
+	session.Values[epochField] = epoch
+	return nil
+}
+
+// epochCurrent reports whether the session's epoch is still the user's
+// current epoch.
+func (m *Manager) epochCurrent(session *sessions.Session) bool {
+	userKey, ok := session.Values[userField].(string)
+	if !ok {
+		return true
+	}
+	epoch, err := m.epochSource().Epoch(userKey)
+	if err != nil {
+		m.logger().Error("secure: fetching session epoch failed", "error", err)
+		return false
+	}
+	return session.Values[epochField] == epoch
+}
+
+/*
+RevokeAll rejects all existing sessions of the user, e.g. after a password
+change, on their next request. Sessions created after RevokeAll are not
+affected.
+
+'userKey' is the key that Session.UserKey returns for the user's record.
+*/
+func (m *Manager) RevokeAll(userKey string) error {
+	return m.epochSource().Bump(userKey)
+}
+
+/*
+RevokeAll calls RevokeAll on the default Manager.
+*/
+func RevokeAll(userKey string) error {
+	return manager.RevokeAll(userKey)
+}
@@ -0,0 +1,43 @@
+package secure
+
+import "testing"
+
+func TestRevokeAll(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.UserKey = func(record interface{}) string {
+			return record.(testRecord).Name
+		}
+	})
+	laptop, phone, other := newClient(), newClient(), newClient()
+	laptop.logIn(t, m, testRecord{"alice"})
+	phone.logIn(t, m, testRecord{"alice"})
+	other.logIn(t, m, testRecord{"bob"})
+	if err := m.RevokeAll("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := laptop.authentication(m); ok {
+		t.Error("laptop session accepted after RevokeAll")
+	}
+	if _, ok := phone.authentication(m); ok {
+		t.Error("phone session accepted after RevokeAll")
+	}
+	if _, ok := other.authentication(m); !ok {
+		t.Error("other user's session rejected")
+	}
+	laptop.logIn(t, m, testRecord{"alice"})
+	if _, ok := laptop.authentication(m); !ok {
+		t.Error("new session rejected after RevokeAll")
+	}
+}
+
+func TestRevokeAllWithoutUserKey(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	if err := m.RevokeAll("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.authentication(m); !ok {
+		t.Error("session rejected; RevokeAll should have no effect without Session.UserKey")
+	}
+}
@@ -0,0 +1,113 @@
+package secure
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Logger is the interface for the package's log output. A *slog.Logger
+// implements it. The default is slog.Default().
+type Logger interface {
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// EventType identifies the kind of security Event.
+type EventType int
+
+const (
+
+	// EventLogIn is sent when LogIn() created a session.
+	EventLogIn EventType = iota
+
+	// EventLogOut is sent when LogOut() deleted a session.
+	EventLogOut
+
+	// EventAuthenticationFailure is sent when the client presented a session
+	// cookie that was rejected.
+	EventAuthenticationFailure
+
+	// EventValidation is sent when the ValidateCookie function refreshed the
+	// authentication data.
+	EventValidation
+
+	// EventFormTokenRejected is sent when a request carried an invalid form
+	// token.
+	EventFormTokenRejected
+
+	// EventKeyRotation is sent when the session or token keys got rotated.
+	EventKeyRotation
+
+	// EventSyncFailure is sent when syncing with the DB failed.
+	EventSyncFailure
+)
+
+var eventTypeNames = [...]string{
+	EventLogIn:                 "login",
+	EventLogOut:                "logout",
+	EventAuthenticationFailure: "authentication_failure",
+	EventValidation:            "validation",
+	EventFormTokenRejected:     "form_token_rejected",
+	EventKeyRotation:           "key_rotation",
+	EventSyncFailure:           "sync_failure",
+}
+
+func (t EventType) String() string {
+	if t >= 0 && int(t) < len(eventTypeNames) {
+		return eventTypeNames[t]
+	}
+	return "unknown"
+}
+
+/*
+An Event reports a security relevant occurrence, for auditing and alerting.
+Events are delivered to Config.OnEvent.
+*/
+type Event struct {
+
+	// Type is the kind of event.
+	Type EventType
+
+	// Time is when the event occurred.
+	Time time.Time
+
+	// Request is the request that caused the event; nil for events from
+	// background syncing.
+	Request *http.Request
+
+	// Record is the authentication data involved, if any.
+	Record interface{}
+
+	// Reason is a short explanation:
+	//  - for an authentication failure: "invalid", "expired", "revoked",
+	//    "denied", or "invalidated"
+	//  - for a rejected form token: "invalid", "mismatch", "expired", or
+	//    "replayed"
+	//  - for a key rotation: "session" or "token"
+	Reason string
+
+	// Err is the error that caused the event, if any.
+	Err error
+}
+
+// logger returns the configured Logger, or slog.Default().
+func (c *Config) logger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
+func (m *Manager) logger() Logger {
+	return m.config.Load().logger()
+}
+
+// event delivers the event to the OnEvent hook, if any.
+func (m *Manager) event(e Event) {
+	if onEvent := m.config.Load().OnEvent; onEvent != nil {
+		e.Time = time.Now()
+		onEvent(e)
+	}
+}
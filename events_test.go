@@ -0,0 +1,92 @@
+package secure
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testLogger records the log messages.
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) log(level, msg string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprint(level, " ", msg, args))
+}
+
+func (l *testLogger) Info(msg string, args ...any)  { l.log("INFO", msg, args...) }
+func (l *testLogger) Warn(msg string, args ...any)  { l.log("WARN", msg, args...) }
+func (l *testLogger) Error(msg string, args ...any) { l.log("ERROR", msg, args...) }
+
+func (l *testLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, message := range l.messages {
+		if strings.Contains(message, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEvents(t *testing.T) {
+	var events []string
+	m := newTestManager(t, func(config *Config) {
+		config.Logger = new(testLogger)
+		config.OnEvent = func(e Event) {
+			if e.Time.IsZero() {
+				t.Errorf("%v event without Time", e.Type)
+			}
+			events = append(events, e.Type.String()+":"+e.Reason)
+		}
+	})
+	m.Stop()
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	c.do(m.VerifyFormToken(http.NotFoundHandler()), newRequest("POST", "/", nil))
+	c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.LogOut(w, r, false)
+	}), newRequest("POST", "/session", nil))
+	c.cookies[m.session().cookieName()] = &http.Cookie{Name: m.session().cookieName(), Value: "forged"}
+	c.authentication(m)
+	rotate(t, m)
+
+	want := []string{
+		"login:",
+		"form_token_rejected:invalid",
+		"logout:",
+		"authentication_failure:invalid",
+		"key_rotation:session",
+		"key_rotation:token",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("got %v; want %v", events, want)
+	}
+}
+
+func TestFormTokenEncodingError(t *testing.T) {
+	logger := new(testLogger)
+	m := newTestManager(t, func(config *Config) {
+		config.Logger = logger
+		// Invalid encryption keys
+		for i := 1; i < len(config.Token.KeyPairs); i += 2 {
+			config.Token.KeyPairs[i] = []byte("short")
+		}
+	})
+	f := m.NewFormToken(newRequest("GET", "/", nil))
+	if s := f.String(); s != "" {
+		t.Errorf("got %q; want an empty string", s)
+	}
+	if !logger.contains("encoding form token failed") {
+		t.Error("error wasn't logged through Config.Logger")
+	}
+	if _, err := f.Encode(); err == nil {
+		t.Error("Encode didn't return the error")
+	}
+}
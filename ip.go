@@ -0,0 +1,127 @@
+package secure
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseProxies parses the TrustedProxies CIDRs. A plain IP address is taken as
+// a single host network.
+func parseProxies(cidrs []string) (proxies []*net.IPNet, err error) {
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil {
+				bits := 8 * len(ip.To16())
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		if _, network, e := net.ParseCIDR(cidr); e != nil {
+			err = fmt.Errorf("secure: invalid trusted proxy %q: %w", cidr, e)
+		} else {
+			proxies = append(proxies, network)
+		}
+	}
+	return
+}
+
+func (t *Token) trusted(ip net.IP) bool {
+	for _, network := range t.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the client's IP address. If the request comes from a
+// trusted proxy, the forwarding headers are followed back, up to the first
+// address that isn't a trusted proxy.
+func (t *Token) clientIP(r *http.Request) string {
+	host := hostOnly(r.RemoteAddr)
+	ip := net.ParseIP(host)
+	if ip == nil || !t.trusted(ip) {
+		return host
+	}
+	hops := forwardedHops(r, t.forwardedHeader())
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hostOnly(hops[i]))
+		if hop == nil {
+			// Obfuscated or unknown; the last trusted hop is all we know
+			break
+		}
+		ip = hop
+		if !t.trusted(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+const defaultForwardedHeader = "X-Forwarded-For"
+
+func (t *Token) forwardedHeader() string {
+	if t.ForwardedHeader != "" {
+		return http.CanonicalHeaderKey(t.ForwardedHeader)
+	}
+	return defaultForwardedHeader
+}
+
+// forwardedHops lists the client and proxy addresses from the given header,
+// from the original client to the last proxy. The Forwarded header is parsed
+// according to RFC 7239; any other header as a comma separated list.
+func forwardedHops(r *http.Request, name string) (hops []string) {
+	for _, header := range r.Header.Values(name) {
+		for _, element := range strings.Split(header, ",") {
+			if name != "Forwarded" {
+				if hop := strings.TrimSpace(element); hop != "" {
+					hops = append(hops, hop)
+				}
+				continue
+			}
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return
+}
+
+// hostOnly strips the port, and the brackets around an IPv6 address.
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// ipMatch reports whether the IP addresses match, according to the IPBinding.
+func (t *Token) ipMatch(a, b string) bool {
+	switch t.IPBinding {
+	case IPNone:
+		return true
+	case IPPrefix:
+		ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+		if ipA == nil || ipB == nil {
+			return a == b
+		}
+		mask := net.CIDRMask(64, 128)
+		if ipA.To4() != nil {
+			ipA, ipB, mask = ipA.To4(), ipB.To4(), net.CIDRMask(24, 32)
+			if ipB == nil {
+				return false
+			}
+		}
+		return ipA.Mask(mask).Equal(ipB.Mask(mask))
+	default:
+		return a == b
+	}
+}
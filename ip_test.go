@@ -0,0 +1,122 @@
+package secure
+
+import "testing"
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "IPv4",
+			remoteAddr: "192.0.2.1:1234",
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "IPv6",
+			remoteAddr: "[2001:db8::1]:443",
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "untrusted remote",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "X-Forwarded-For",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "X-Forwarded-For spoofed by the client",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.77, 203.0.113.9, 10.0.0.2"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "Forwarded from the client",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.9",
+				"Forwarded":       "for=198.51.100.77",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:       "X-Real-IP from the client",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "203.0.113.9",
+				"X-Real-IP":       "198.51.100.77",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:       "Forwarded",
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "198.51.100.77",
+				"Forwarded":       `for=198.51.100.78, for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`,
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:       "Forwarded obfuscated",
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"Forwarded": "for=_hidden, for=10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "X-Real-IP",
+			header:     "x-real-ip",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "198.51.100.77",
+				"X-Real-IP":       "203.0.113.9",
+			},
+			want: "203.0.113.9",
+		},
+		{
+			name:       "header missing",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := &Token{TrustedProxies: []string{"10.0.0.0/8"}, ForwardedHeader: test.header}
+			var err error
+			if token.proxies, err = parseProxies(token.TrustedProxies); err != nil {
+				t.Fatal(err)
+			}
+			r := newRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			if got := token.clientIP(r); got != test.want {
+				t.Errorf("got %s; want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	proxies, err := parseProxies([]string{"10.0.0.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 2 || proxies[0].String() != "10.0.0.1/32" || proxies[1].String() != "2001:db8::/32" {
+		t.Errorf("got %v", proxies)
+	}
+	if _, err := parseProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
@@ -0,0 +1,155 @@
+package secure
+
+import (
+	"context"
+	gorilla "github.com/gorilla/context"
+	"net/http"
+)
+
+// withValue returns the request with the value in its context, so that it
+// survives requests derived with WithContext(). The derived request shares
+// the gorilla context, e.g. the loaded sessions.
+func withValue(r *http.Request, key, value interface{}) *http.Request {
+	derived := r.WithContext(context.WithValue(r.Context(), key, value))
+	for key, value := range gorilla.GetAll(r) {
+		gorilla.Set(derived, key, value)
+	}
+	return derived
+}
+
+// withAuthentication returns the request with the authentication record in
+// its context.
+func (m *Manager) withAuthentication(r *http.Request) *http.Request {
+	record, ok := m.authentication(r)
+	if !ok {
+		return r
+	}
+	return withValue(r, authKey{m}, authValue{record})
+}
+
+// verifiedKey marks the request context of a request that passed the
+// Manager's CSRF check; its value is the CSRFMode.
+type verifiedKey struct {
+	m *Manager
+}
+
+// verified returns the CSRFMode that the request passed the CSRF check with.
+func (m *Manager) verified(r *http.Request) (mode CSRFMode, ok bool) {
+	mode, ok = r.Context().Value(verifiedKey{m}).(CSRFMode)
+	return
+}
+
+// serve calls the next handler, and clears the gorilla context of the
+// requests afterwards.
+func serve(next http.Handler, w http.ResponseWriter, r, derived *http.Request) {
+	defer gorilla.Clear(r)
+	if derived != r {
+		defer gorilla.Clear(derived)
+	}
+	next.ServeHTTP(w, derived)
+}
+
+/*
+RequireAuthentication is middleware that passes the requests of logged in
+clients on to the next handler, which can call Authentication() to get the
+client's authentication data. Other requests get the response of
+Config.Unauthenticated.
+*/
+func (m *Manager) RequireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := m.orDefault()
+		if m.authenticate(w, r, false) {
+			serve(next, w, r, m.withAuthentication(r))
+		} else {
+			gorilla.Clear(r)
+		}
+	})
+}
+
+/*
+RequireAuthentication calls RequireAuthentication on the default Manager.
+*/
+func RequireAuthentication(next http.Handler) http.Handler {
+	return (*Manager)(nil).RequireAuthentication(next)
+}
+
+/*
+OptionalAuthentication is middleware that passes all requests on to the next
+handler, which can call Authenticated() to check whether the client is logged
+in, and Authentication() to get the client's authentication data.
+*/
+func (m *Manager) OptionalAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := m.orDefault()
+		m.authenticate(w, r, true)
+		serve(next, w, r, m.withAuthentication(r))
+	})
+}
+
+/*
+OptionalAuthentication calls OptionalAuthentication on the default Manager.
+*/
+func OptionalAuthentication(next http.Handler) http.Handler {
+	return (*Manager)(nil).OptionalAuthentication(next)
+}
+
+// safeMethod reports whether the request method doesn't change state, so that
+// it needs no CSRF check.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+/*
+VerifyFormToken is middleware that passes requests with a safe method (GET,
+HEAD, OPTIONS, TRACE), and requests with a valid FormToken, on to the next
+handler. The token is looked for like in the SecureRouter. Other requests get
+the response of Config.TokenInvalid.
+*/
+func (m *Manager) VerifyFormToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := m.orDefault()
+		if safeMethod(r.Method) {
+			serve(next, w, r, r)
+		} else if m.verifyFormToken(w, r) {
+			serve(next, w, r, withValue(r, verifiedKey{m}, FormTokenMode))
+		} else {
+			gorilla.Clear(r)
+		}
+	})
+}
+
+/*
+VerifyFormToken calls VerifyFormToken on the default Manager.
+*/
+func VerifyFormToken(next http.Handler) http.Handler {
+	return (*Manager)(nil).VerifyFormToken(next)
+}
+
+/*
+VerifyDoubleSubmit is middleware that passes requests with a safe method (GET,
+HEAD, OPTIONS, TRACE), and requests with a valid DoubleSubmitToken(), on to the
+next handler. Other requests get the response of Config.TokenInvalid.
+*/
+func (m *Manager) VerifyDoubleSubmit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := m.orDefault()
+		if safeMethod(r.Method) {
+			serve(next, w, r, r)
+		} else if m.verifyDoubleSubmit(w, r) {
+			serve(next, w, r, withValue(r, verifiedKey{m}, DoubleSubmitMode))
+		} else {
+			gorilla.Clear(r)
+		}
+	})
+}
+
+/*
+VerifyDoubleSubmit calls VerifyDoubleSubmit on the default Manager.
+*/
+func VerifyDoubleSubmit(next http.Handler) http.Handler {
+	return (*Manager)(nil).VerifyDoubleSubmit(next)
+}
@@ -0,0 +1,106 @@
+package secure
+
+import (
+	"context"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"testing"
+)
+
+func TestVerifyFormTokenReferer(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	token := c.formToken(m, "/page", "192.0.2.1:1234")
+	post := func(referer string) int {
+		r := newRequest("POST", "/form", nil)
+		r.Header.Set(defaultHeaderName, token)
+		r.Header.Set("Referer", referer)
+		return c.do(m.VerifyFormToken(okHandler), r).Code
+	}
+	if code := post("https://example.com/page"); code != http.StatusOK {
+		t.Errorf("token for the referring page: got %d; want 200", code)
+	}
+	if code := post("https://example.com/other"); code != http.StatusBadRequest {
+		t.Errorf("token for another page: got %d; want 400", code)
+	}
+	if code := post("http://[::1"); code != http.StatusBadRequest {
+		t.Errorf("malformed Referer: got %d; want 400", code)
+	}
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	m := newTestManager(t)
+	var got interface{}
+	h := m.RequireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = m.Authentication(r)
+	}))
+	c := newClient()
+	if code := c.do(h, newRequest("GET", "/", nil)).Code; code != http.StatusForbidden || got != nil {
+		t.Errorf("logged out: got %d, %v; want 403 without calling the handler", code, got)
+	}
+	c.logIn(t, m, testRecord{"alice"})
+	if code := c.do(h, newRequest("GET", "/", nil)).Code; code != http.StatusOK || got != (testRecord{"alice"}) {
+		t.Errorf("logged in: got %d, %v; want 200, alice", code, got)
+	}
+}
+
+func TestOptionalAuthentication(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	if _, ok := c.authentication(m); ok {
+		t.Error("logged out client authenticated")
+	}
+	c.logIn(t, m, testRecord{"alice"})
+	if got, ok := c.authentication(m); !ok || got != (testRecord{"alice"}) {
+		t.Errorf("got %v, %v; want alice", got, ok)
+	}
+}
+
+type ctxKey struct{}
+
+func TestServeMux(t *testing.T) {
+	m := newTestManager(t)
+	var got interface{}
+	mux := http.NewServeMux()
+	mux.Handle("/account/", m.RequireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The record survives a request derived by other middleware
+		r = r.WithContext(context.WithValue(r.Context(), ctxKey{}, r.URL.Path))
+		got = m.Authentication(r)
+	})))
+	mux.Handle("/form/", m.VerifyFormToken(m.RequireAuthentication(okHandler)))
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	if code := c.do(mux, newRequest("GET", "/account/1", nil)).Code; code != http.StatusOK || got != (testRecord{"alice"}) {
+		t.Errorf("GET: got %d, %v; want 200, alice", code, got)
+	}
+	if code := c.do(mux, newRequest("POST", "/form/1", nil)).Code; code != http.StatusBadRequest {
+		t.Errorf("POST without token: got %d; want 400", code)
+	}
+	r := newRequest("POST", "/form/1", nil)
+	r.Header.Set(defaultHeaderName, c.formToken(m, "/form/1", "192.0.2.1:1234"))
+	if code := c.do(mux, r).Code; code != http.StatusOK {
+		t.Errorf("POST with token: got %d; want 200", code)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	m := newTestManager(t)
+	var authenticated []bool
+	handle := m.IfHandle(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		authenticated = append(authenticated, true)
+	}, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		authenticated = append(authenticated, false)
+	})
+	serve := func(c *client) {
+		c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handle(w, r, nil)
+		}), newRequest("GET", "/", nil))
+	}
+	c := newClient()
+	serve(c)
+	c.logIn(t, m, testRecord{"alice"})
+	serve(c)
+	if len(authenticated) != 2 || authenticated[0] || !authenticated[1] {
+		t.Errorf("got %v; want [false true]", authenticated)
+	}
+}
@@ -0,0 +1,75 @@
+package secure
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// NonceStore records the nonces of FormTokens that have been used, for
+// Token.OneTimeUse. Implement it on a shared store (e.g. Redis) when running
+// multiple servers.
+type NonceStore interface {
+
+	// Use records the nonce as used, until it expires. It reports whether the
+	// nonce wasn't used before.
+	Use(nonce string, expires time.Time) (fresh bool, err error)
+}
+
+// defaultNonceCapacity is the capacity of the default NonceStore.
+const defaultNonceCapacity = 100000
+
+type nonceEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+type memoryNonceStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+// NewMemoryNonceStore returns an in-memory NonceStore, holding at most
+// 'capacity' nonces; when full, the least recently used nonce is dropped.
+// A capacity of 0 or less gets the default of 100000.
+// It's the default for Token.Nonces.
+func NewMemoryNonceStore(capacity int) NonceStore {
+	if capacity <= 0 {
+		capacity = defaultNonceCapacity
+	}
+	return &memoryNonceStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (s *memoryNonceStore) Use(nonce string, expires time.Time) (fresh bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if element, ok := s.entries[nonce]; ok {
+		entry := element.Value.(*nonceEntry)
+		if now.Before(entry.expires) {
+			s.lru.MoveToFront(element)
+			return false, nil
+		}
+		s.remove(element)
+	}
+	// Drop expired entries from the tail, then make room
+	for element := s.lru.Back(); element != nil; element = s.lru.Back() {
+		if entry := element.Value.(*nonceEntry); now.Before(entry.expires) && s.lru.Len() < s.capacity {
+			break
+		}
+		s.remove(element)
+	}
+	s.entries[nonce] = s.lru.PushFront(&nonceEntry{nonce, expires})
+	return true, nil
+}
+
+func (s *memoryNonceStore) remove(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.entries, element.Value.(*nonceEntry).nonce)
+}
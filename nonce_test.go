@@ -0,0 +1,38 @@
+package secure
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryNonceStore(t *testing.T) {
+	store := NewMemoryNonceStore(2)
+	later := time.Now().Add(time.Hour)
+	use := func(nonce string, expires time.Time, want bool) {
+		t.Helper()
+		if fresh, err := store.Use(nonce, expires); err != nil || fresh != want {
+			t.Errorf("Use(%s) = %v, %v; want %v", nonce, fresh, err, want)
+		}
+	}
+	use("a", later, true)
+	use("a", later, false)
+	use("b", later, true)
+	// At capacity; "a" was used more recently than "b"
+	use("a", later, false)
+	use("c", later, true)
+	use("b", later, true)
+	// Expired nonces can be used again
+	use("d", time.Now().Add(-time.Second), true)
+	use("d", later, true)
+}
+
+func TestMemoryNonceStoreCapacity(t *testing.T) {
+	for _, capacity := range []int{0, -1} {
+		store := NewMemoryNonceStore(capacity)
+		later := time.Now().Add(time.Hour)
+		store.Use("a", later)
+		if fresh, _ := store.Use("a", later); fresh {
+			t.Errorf("capacity %d: replay accepted", capacity)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package secure
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/*
+A Problem describes an error response, as problem details (RFC 9457). It's
+passed to the Config's ProblemHandlers.
+*/
+type Problem struct {
+
+	// Type is a URI reference that identifies the problem type.
+	// Default value is "about:blank".
+	Type string `json:"type,omitempty"`
+
+	// Title is a short summary of the problem type.
+	Title string `json:"title"`
+
+	// Status is the HTTP status code.
+	Status int `json:"status"`
+
+	// Detail explains this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+
+	// Instance is the path of the request.
+	Instance string `json:"instance,omitempty"`
+
+	// Reason is the Event's Reason, e.g. "expired".
+	Reason string `json:"reason,omitempty"`
+
+	// Location is where to go next: the log in page for an unauthenticated
+	// client, or the previous page for an invalid token.
+	Location string `json:"location,omitempty"`
+
+	// Expected is the FormToken as the request should have had it, for a
+	// failed FormToken.
+	Expected *FormToken `json:"-"`
+
+	// Received is the FormToken the request had, for a failed FormToken.
+	Received *FormToken `json:"-"`
+}
+
+// A ProblemHandler writes the response for a Problem.
+type ProblemHandler func(w http.ResponseWriter, r *http.Request, problem *Problem)
+
+/*
+WriteProblem writes the Problem as JSON, with the media type
+"application/problem+json".
+*/
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// acceptance is the quality that an Accept header gives a media type, from its
+// most specific matching media range.
+type acceptance struct {
+	q           float64
+	specificity int
+}
+
+// match applies the media range, of the given specificity: 1 for */*, 2 for
+// type/*, and 3 for a full media type.
+func (a *acceptance) match(q float64, specificity int) {
+	if specificity > a.specificity || (specificity == a.specificity && q > a.q) {
+		a.q, a.specificity = q, specificity
+	}
+}
+
+// wantsJSON reports whether the client prefers JSON over HTML, according to
+// its Accept header. On equal quality, a listed media type beats a wildcard,
+// e.g. for "application/json, text/plain, */*". Browsers, and clients without
+// preference, get HTML.
+func wantsJSON(r *http.Request) bool {
+	var htmlAccept, jsonAccept acceptance
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(accept, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(value, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/html", "application/xhtml+xml":
+			htmlAccept.match(q, 3)
+		case "text/*":
+			htmlAccept.match(q, 2)
+		case "application/json", "application/problem+json":
+			jsonAccept.match(q, 3)
+		case "application/*":
+			jsonAccept.match(q, 2)
+		case "*/*":
+			htmlAccept.match(q, 1)
+			jsonAccept.match(q, 1)
+		}
+	}
+	if jsonAccept.q != htmlAccept.q {
+		return jsonAccept.q > htmlAccept.q
+	}
+	return jsonAccept.q > 0 && jsonAccept.specificity > htmlAccept.specificity
+}
+
+// unauthenticatedHandler is the default Config.Unauthenticated.
+func (c *Config) unauthenticatedHandler(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	if wantsJSON(r) {
+		w.Header().Set("WWW-Authenticate", `Cookie realm="secure", form-action="`+problem.Location+`"`)
+		WriteProblem(w, r, problem)
+		return
+	}
+	c.render(w, UnauthenticatedTemplate, http.StatusForbidden, problem)
+}
+
+// forbiddenHandler is the default Config.Forbidden.
+func (c *Config) forbiddenHandler(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	if wantsJSON(r) {
+		WriteProblem(w, r, problem)
+		return
+	}
+	c.render(w, ForbiddenTemplate, problem.Status, problem)
+}
+
+// tokenInvalidHandler is the default Config.TokenInvalid.
+func (c *Config) tokenInvalidHandler(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	if wantsJSON(r) {
+		WriteProblem(w, r, problem)
+		return
+	}
+	c.render(w, TokenInvalidTemplate, problem.Status, problem)
+}
+
+func (c *Config) unauthenticated() ProblemHandler {
+	if c.Unauthenticated != nil {
+		return c.Unauthenticated
+	}
+	return c.unauthenticatedHandler
+}
+
+func (c *Config) forbidden() ProblemHandler {
+	if c.Forbidden != nil {
+		return c.Forbidden
+	}
+	return c.forbiddenHandler
+}
+
+func (c *Config) tokenInvalid() ProblemHandler {
+	if c.TokenInvalid != nil {
+		return c.TokenInvalid
+	}
+	return c.tokenInvalidHandler
+}
+
+// public removes the diagnostic details from the Problem in Production mode;
+// they're logged instead.
+func (c *Config) public(problem *Problem) *Problem {
+	if c.Production {
+		problem.Reason, problem.Expected, problem.Received = "", nil, nil
+	}
+	return problem
+}
+
+/*
+Forbidden responds that the client isn't allowed to access the resource,
+through Config.Forbidden. Call it from a Handle, e.g. when the authenticated
+user lacks the required role.
+*/
+func (m *Manager) Forbidden(w http.ResponseWriter, r *http.Request) {
+	m = m.orDefault()
+	config := m.current()
+	config.forbidden()(w, r, &Problem{
+		Title:    "Forbidden",
+		Status:   http.StatusForbidden,
+		Instance: r.URL.Path,
+	})
+}
+
+/*
+Forbidden calls Forbidden on the default Manager.
+*/
+func Forbidden(w http.ResponseWriter, r *http.Request) {
+	(*Manager)(nil).Forbidden(w, r)
+}
+
+// tokenInvalid responds that the request's token failed, through
+// Config.TokenInvalid.
+func (m *Manager) tokenInvalid(w http.ResponseWriter, r *http.Request, title, reason string, this, that *FormToken) {
+	location := ""
+	if referer, err := url.Parse(r.Referer()); err == nil {
+		location = referer.Path
+	}
+	config := m.current()
+	config.tokenInvalid()(w, r, config.public(&Problem{
+		Title:    title,
+		Status:   http.StatusBadRequest,
+		Instance: r.URL.Path,
+		Reason:   reason,
+		Location: location,
+		Expected: this,
+		Received: that,
+	}))
+}
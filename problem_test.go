@@ -0,0 +1,142 @@
+package secure
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"application/json", true},
+		{"application/problem+json", true},
+		{"application/json, text/html;q=0.5", true},
+		{"text/html;q=0.5, application/json;q=0.4", false},
+		{"application/*", true},
+		{"APPLICATION/JSON", true},
+		{"application/json, text/plain, */*", true},
+		{"application/*, text/html", false},
+		{"application/json;q=0, */*", false},
+		{"text/*, application/json;q=0.9", false},
+	}
+	for _, test := range tests {
+		r := newRequest("GET", "/", nil)
+		r.Header.Set("Accept", test.accept)
+		if got := wantsJSON(r); got != test.want {
+			t.Errorf("wantsJSON(%q) = %v; want %v", test.accept, got, test.want)
+		}
+	}
+}
+
+// decodeProblem decodes the response body as problem details.
+func decodeProblem(t *testing.T, header http.Header, body string) (problem Problem) {
+	t.Helper()
+	if got := header.Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("got Content-Type %q; want application/problem+json", got)
+	}
+	if err := json.Unmarshal([]byte(body), &problem); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestUnauthenticated(t *testing.T) {
+	m := newTestManager(t)
+
+	r := newRequest("GET", "/account", nil)
+	r.Header.Set("Accept", "application/json")
+	w := newClient().do(m.RequireAuthentication(okHandler), r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("JSON: got %d; want 401", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); !strings.Contains(got, `form-action="/session"`) {
+		t.Errorf("got WWW-Authenticate %q", got)
+	}
+	problem := decodeProblem(t, w.Header(), w.Body.String())
+	if problem.Status != http.StatusUnauthorized || problem.Location != "/session" || problem.Instance != "/account" {
+		t.Errorf("got %+v", problem)
+	}
+
+	r = newRequest("GET", "/account", nil)
+	r.Header.Set("Accept", "text/html,*/*;q=0.8")
+	w = newClient().do(m.RequireAuthentication(okHandler), r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("HTML: got %d; want 403", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `url=/session`) {
+		t.Errorf("got %s; want a refresh to the log in page", body)
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	m := newTestManager(t)
+	forbidden := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Forbidden(w, r)
+	})
+
+	r := newRequest("GET", "/admin", nil)
+	r.Header.Set("Accept", "application/json")
+	w := newClient().do(forbidden, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("JSON: got %d; want 403", w.Code)
+	}
+	if problem := decodeProblem(t, w.Header(), w.Body.String()); problem.Title != "Forbidden" || problem.Instance != "/admin" {
+		t.Errorf("got %+v", problem)
+	}
+
+	w = newClient().do(forbidden, newRequest("GET", "/admin", nil))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "<h2>Forbidden</h2>") {
+		t.Errorf("HTML: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTokenInvalid(t *testing.T) {
+	m := newTestManager(t)
+	r := newRequest("POST", "/form", nil)
+	r.Header.Set("Accept", "application/json")
+	w := newClient().do(m.VerifyFormToken(okHandler), r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d; want 400", w.Code)
+	}
+	if problem := decodeProblem(t, w.Header(), w.Body.String()); problem.Reason != "invalid" || problem.Instance != "/form" {
+		t.Errorf("got %+v", problem)
+	}
+}
+
+func TestProblemHandlers(t *testing.T) {
+	var got []string
+	handler := func(name string) ProblemHandler {
+		return func(w http.ResponseWriter, r *http.Request, problem *Problem) {
+			got = append(got, name)
+			w.WriteHeader(http.StatusTeapot)
+		}
+	}
+	m := newTestManager(t, func(config *Config) {
+		config.Unauthenticated = handler("unauthenticated")
+		config.Forbidden = handler("forbidden")
+		config.TokenInvalid = handler("token-invalid")
+	})
+	c := newClient()
+	for _, h := range []http.Handler{
+		m.RequireAuthentication(okHandler),
+		http.HandlerFunc(m.Forbidden),
+		m.VerifyFormToken(okHandler),
+	} {
+		if w := c.do(h, newRequest("POST", "/", nil)); w.Code != http.StatusTeapot {
+			t.Errorf("got %d; want the custom handler's 418", w.Code)
+		}
+	}
+	if want := []string{"unauthenticated", "forbidden", "token-invalid"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
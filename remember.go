@@ -0,0 +1,300 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A LogInOption modifies how LogIn() creates the session.
+type LogInOption func(*logInOptions)
+
+type logInOptions struct {
+	persistent bool
+}
+
+/*
+Persistent keeps the client logged in after the browser closes ("keep me signed
+in"). Next to the session cookie, which always ends with the browser session,
+LogIn() sets a remember-me cookie that lasts Session.RememberTimeOut. When the
+session is gone, the remember-me token silently logs the client in again, and
+is replaced with a new one. Reuse of a replaced token indicates that it was
+stolen; the token series then ends, and if Session.UserKey is set, all the
+user's sessions are revoked.
+*/
+func Persistent() LogInOption {
+	return func(o *logInOptions) {
+		o.persistent = true
+	}
+}
+
+/*
+BrowserSession has the client logged out when the browser closes. It's the
+default.
+*/
+func BrowserSession() LogInOption {
+	return func(o *logInOptions) {
+		o.persistent = false
+	}
+}
+
+// A Remembered is the server side data of a remember-me token series.
+type Remembered struct {
+
+	// Hash is the SHA-256 hash of the current token.
+	Hash []byte
+
+	// PreviousHash is the hash of the token that was replaced at Rotated; it
+	// stays valid for a moment, for concurrent requests.
+	PreviousHash []byte
+
+	// Rotated is when the token was last replaced.
+	Rotated time.Time
+
+	// Data is the encrypted authentication data.
+	Data string
+
+	// Expires is when the series ends.
+	Expires time.Time
+}
+
+/*
+A RememberStore keeps the remember-me token series. Implement it on the
+application's database to share the series between servers.
+*/
+type RememberStore interface {
+
+	// Load returns the series, or nil if there is none.
+	Load(series string) (*Remembered, error)
+
+	// Save stores the series.
+	Save(series string, remembered *Remembered) error
+
+	// Delete removes the series.
+	Delete(series string) error
+}
+
+type memoryRememberStore struct {
+	mu     sync.Mutex
+	series map[string]Remembered
+	purged time.Time
+}
+
+/*
+NewMemoryRememberStore returns a RememberStore that keeps the series in memory.
+It's the default for Session.Remember.
+*/
+func NewMemoryRememberStore() RememberStore {
+	return &memoryRememberStore{series: make(map[string]Remembered)}
+}
+
+func (s *memoryRememberStore) Load(series string) (*Remembered, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if remembered, ok := s.series[series]; ok {
+		return &remembered, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryRememberStore) Save(series string, remembered *Remembered) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.series[series] = *remembered
+	s.purge()
+	return nil
+}
+
+func (s *memoryRememberStore) Delete(series string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.series, series)
+	return nil
+}
+
+// purge drops the expired series, at most once a minute.
+func (s *memoryRememberStore) purge() {
+	now := time.Now()
+	if now.Sub(s.purged) < time.Minute {
+		return
+	}
+	s.purged = now
+	for id, remembered := range s.series {
+		if !now.Before(remembered.Expires) {
+			delete(s.series, id)
+		}
+	}
+}
+
+const (
+	rememberName = "9a1f6c3e-4b8d-4e27-8c5a-d2f07b3e9164"
+
+	defaultRememberTimeOut = 30 * 24 * time.Hour
+
+	// rememberGrace is how long a replaced token stays valid.
+	rememberGrace = 30 * time.Second
+)
+
+// rememberedRecord wraps the authentication data, for encoding it as an
+// interface value.
+type rememberedRecord struct {
+	Record interface{}
+	User   string
+	Epoch  uint64
+}
+
+func (s *Session) rememberTimeOut() time.Duration {
+	if s.RememberTimeOut > 0 {
+		return s.RememberTimeOut
+	}
+	return defaultRememberTimeOut
+}
+
+func (m *Manager) rememberStore() RememberStore {
+	if remember := m.session().Remember; remember != nil {
+		return remember
+	}
+	return m.series
+}
+
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// setRememberCookie sets the remember-me cookie, with the Domain, Path,
+// SameSite, and Partitioned attributes of the session cookie. It's always
+// HttpOnly.
+func (m *Manager) setRememberCookie(w http.ResponseWriter, value string, maxAge int) {
+	config := m.session()
+	http.SetCookie(w, &http.Cookie{
+		Name:        config.rememberCookieName(),
+		Value:       value,
+		Domain:      config.Cookie.Domain,
+		Path:        config.Cookie.path(),
+		MaxAge:      maxAge,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    config.Cookie.sameSite(),
+		Partitioned: config.Cookie.Partitioned,
+	})
+}
+
+// remember starts a new remember-me token series for the session.
+func (m *Manager) remember(w http.ResponseWriter, session *sessions.Session) error {
+	config := m.session()
+	data := &rememberedRecord{Record: session.Values[recordField]}
+	data.User, _ = session.Values[userField].(string)
+	data.Epoch, _ = session.Values[epochField].(uint64)
+	encoded, err := securecookie.EncodeMulti(rememberName, data, config.rememberCodecs...)
+	if err != nil {
+		return err
+	}
+	series, token := newID(), newID()
+	remembered := &Remembered{
+		Hash:    hashToken(token),
+		Rotated: time.Now(),
+		Data:    encoded,
+		Expires: time.Now().Add(config.rememberTimeOut()),
+	}
+	if err := m.rememberStore().Save(series, remembered); err != nil {
+		return err
+	}
+	m.setRememberCookie(w, series+":"+token, int(config.rememberTimeOut()/time.Second))
+	return nil
+}
+
+// forget ends the request's remember-me token series.
+func (m *Manager) forget(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(m.session().rememberCookieName())
+	if err != nil {
+		return
+	}
+	if series, _, ok := strings.Cut(cookie.Value, ":"); ok {
+		if err := m.rememberStore().Delete(series); err != nil {
+			m.logger().Error("secure: deleting remember-me series failed", "error", err)
+		}
+	}
+	m.setRememberCookie(w, "", -1)
+}
+
+// recall logs the client in again from the request's remember-me token, if
+// it's valid, and replaces the token. It returns the new session, or nil.
+func (m *Manager) recall(w http.ResponseWriter, r *http.Request) (session *sessions.Session) {
+	cookie, err := r.Cookie(m.session().rememberCookieName())
+	if err != nil {
+		return
+	}
+	store := m.rememberStore()
+	series, token, _ := strings.Cut(cookie.Value, ":")
+	remembered, err := store.Load(series)
+	if err != nil {
+		m.logger().Error("secure: loading remember-me series failed", "error", err)
+		return
+	}
+	if remembered == nil || !time.Now().Before(remembered.Expires) {
+		m.forget(w, r)
+		return
+	}
+	data := new(rememberedRecord)
+	if err := securecookie.DecodeMulti(rememberName, remembered.Data, data, m.session().rememberCodecs...); err != nil {
+		m.forget(w, r)
+		return
+	}
+	hash := hashToken(token)
+	current := subtle.ConstantTimeCompare(hash, remembered.Hash) == 1
+	previous := subtle.ConstantTimeCompare(hash, remembered.PreviousHash) == 1 &&
+		time.Since(remembered.Rotated) < rememberGrace
+	if !current && !previous {
+		// A replaced token was used: either the client or a thief holds a copy
+		m.logger().Warn("secure: remember-me token reused; revoking sessions", "ip", m.token().clientIP(r))
+		m.event(Event{Type: EventAuthenticationFailure, Request: r, Record: data.Record, Reason: "stolen"})
+		m.forget(w, r)
+		if data.User != "" {
+			if err := m.RevokeAll(data.User); err != nil {
+				m.logger().Error("secure: revoking sessions failed", "error", err)
+			}
+		}
+		return
+	}
+	if data.User != "" {
+		if epoch, err := m.epochSource().Epoch(data.User); err != nil || epoch != data.Epoch {
+			m.forget(w, r)
+			return
+		}
+	}
+	record, valid := m.validate(data.Record)
+	if !valid {
+		m.forget(w, r)
+		return
+	}
+	if current {
+		// Encode the data with the current keys, so that it outlasts the
+		// session key rotation
+		data.Record = record
+		encoded, err := securecookie.EncodeMulti(rememberName, data, m.session().rememberCodecs...)
+		if err != nil {
+			m.logger().Error("secure: encoding remember-me data failed", "error", err)
+			return
+		}
+		next := newID()
+		remembered.PreviousHash, remembered.Hash = remembered.Hash, hashToken(next)
+		remembered.Data = encoded
+		remembered.Rotated = time.Now()
+		if err := store.Save(series, remembered); err != nil {
+			m.logger().Error("secure: saving remember-me series failed", "error", err)
+			return
+		}
+		m.setRememberCookie(w, series+":"+next, int(time.Until(remembered.Expires)/time.Second))
+	}
+	if session, err = m.create(w, r, record, true); err != nil {
+		return nil
+	}
+	m.event(Event{Type: EventLogIn, Request: r, Record: record, Reason: "remembered"})
+	return
+}
@@ -0,0 +1,126 @@
+package secure
+
+import (
+	"net/http"
+	"testing"
+)
+
+// closeBrowser drops the client's browser session cookies.
+func (c *client) closeBrowser() {
+	for name, cookie := range c.cookies {
+		if cookie.MaxAge == 0 && cookie.Expires.IsZero() {
+			delete(c.cookies, name)
+		}
+	}
+}
+
+func TestPersistent(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"}, Persistent())
+	remember := c.cookies[m.session().rememberCookieName()]
+	if remember == nil || remember.MaxAge <= 0 {
+		t.Fatalf("got remember-me cookie %v; want a persistent one", remember)
+	}
+	c.closeBrowser()
+	if record, ok := c.authentication(m); !ok || record != (testRecord{"alice"}) {
+		t.Fatalf("got %v, %v; want alice, true", record, ok)
+	}
+	if c.cookies[m.session().rememberCookieName()].Value == remember.Value {
+		t.Error("remember-me token wasn't replaced")
+	}
+	c.logOut(m)
+	c.closeBrowser()
+	if _, ok := c.authentication(m); ok {
+		t.Error("remembered after LogOut")
+	}
+}
+
+func TestRememberKeyRotation(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"}, Persistent())
+	for i := 0; i < 3; i++ {
+		rotate(t, m)
+		c.closeBrowser()
+		if record, ok := c.authentication(m); !ok || record != (testRecord{"alice"}) {
+			t.Fatalf("rotation %d: got %v, %v; want alice, true", i+1, record, ok)
+		}
+	}
+	rotate(t, m)
+	rotate(t, m)
+	c.closeBrowser()
+	if _, ok := c.authentication(m); ok {
+		t.Error("remembered after two rotations without use")
+	}
+}
+
+func TestBrowserSession(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	c.closeBrowser()
+	if _, ok := c.authentication(m); ok {
+		t.Error("session survived closing the browser")
+	}
+}
+
+func TestRememberTheft(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.UserKey = func(record interface{}) string {
+			return record.(testRecord).Name
+		}
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"}, Persistent())
+	stolen := c.copyCookies()
+	stolen.closeBrowser()
+	// Replace the token twice, so that the stolen one is outdated
+	for i := 0; i < 2; i++ {
+		c.closeBrowser()
+		if _, ok := c.authentication(m); !ok {
+			t.Fatal("not remembered")
+		}
+	}
+	if _, ok := stolen.authentication(m); ok {
+		t.Error("outdated remember-me token accepted")
+	}
+	if _, ok := c.authentication(m); ok {
+		t.Error("session accepted after the remember-me token was stolen")
+	}
+}
+
+func TestRememberCookieAttributes(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Cookie = Cookie{Name: "admin", Path: "/admin", SameSite: http.SameSiteStrictMode}
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"}, Persistent())
+	remember := c.cookies["__Secure-admin-remember"]
+	if remember == nil {
+		t.Fatalf("got cookies %v; want __Secure-admin-remember", c.cookies)
+	}
+	if remember.Path != "/admin" || remember.SameSite != http.SameSiteStrictMode || !remember.Secure || !remember.HttpOnly {
+		t.Errorf("got %v; want the session cookie's attributes", remember)
+	}
+}
+
+func TestRememberTwoManagers(t *testing.T) {
+	admin := newTestManager(t, func(config *Config) {
+		config.Session.Cookie.Name = "admin"
+	})
+	public := newTestManager(t)
+	c := newClient()
+	c.logIn(t, admin, testRecord{"alice"}, Persistent())
+	c.logIn(t, public, testRecord{"alice"}, Persistent())
+	if _, ok := c.authentication(public); !ok {
+		t.Error("public session rejected")
+	}
+	c.closeBrowser()
+	if _, ok := c.authentication(public); !ok {
+		t.Error("public not remembered")
+	}
+	if _, ok := c.authentication(admin); !ok {
+		t.Error("admin not remembered")
+	}
+}
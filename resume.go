@@ -0,0 +1,142 @@
+package secure
+
+import (
+	"encoding/gob"
+	"github.com/gorilla/sessions"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	stashField = "a6c2e9f4-1b7d-4c3a-8e5f-9d0b2a4c6e18"
+
+	defaultResumeMaxSize = 4096
+
+	// resumeTimeOut is how long a stashed request can be resumed.
+	resumeTimeOut = time.Hour
+
+	// ResubmitTemplate is the name of the template for the page that resumes
+	// a stashed request after LogIn(). It gets a *Resubmission as its data.
+	ResubmitTemplate = "resubmit"
+)
+
+/*
+A StashedRequest is a request that Handle() rejected because the client wasn't
+logged in, saved in the session to resume it after LogIn().
+*/
+type StashedRequest struct {
+
+	// Method is the request's method.
+	Method string `json:"method"`
+
+	// URL is the request URI.
+	URL string `json:"url"`
+
+	// Form is the url-encoded form body, without the FormToken.
+	Form string `json:"form"`
+
+	// Mode is the CSRFMode that the request passed the CSRF check with, and
+	// that the resubmission gets a fresh token for.
+	Mode CSRFMode `json:"mode"`
+
+	// Time is when the request was stashed.
+	Time time.Time `json:"time"`
+}
+
+/*
+A Resubmission is the data of the ResubmitTemplate: the StashedRequest, with
+its form Values, including a fresh FormToken.
+*/
+type Resubmission struct {
+	StashedRequest
+	Values url.Values
+}
+
+func init() {
+	gob.Register(StashedRequest{})
+}
+
+func (s *Session) resumeMaxSize() int {
+	if s.ResumeMaxSize > 0 {
+		return s.ResumeMaxSize
+	}
+	return defaultResumeMaxSize
+}
+
+// stash saves the rejected request in the session, if Session.Resume is set,
+// and it's a POST of a url-encoded form that isn't too large, and that passed
+// the CSRF check. Resubmitting a request that didn't would complete a forged
+// request.
+func (m *Manager) stash(w http.ResponseWriter, r *http.Request, session *sessions.Session) {
+	delete(session.Values, stashField)
+	config := m.session()
+	if !config.Resume || r.Method != http.MethodPost {
+		return
+	}
+	mode, verified := m.verified(r)
+	if !verified {
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		return
+	}
+	maxSize := config.resumeMaxSize()
+	if r.PostForm == nil {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxSize))
+		if err := r.ParseForm(); err != nil {
+			return
+		}
+	}
+	form := make(url.Values, len(r.PostForm))
+	for name, values := range r.PostForm {
+		if name != FormValueName {
+			form[name] = values
+		}
+	}
+	encoded := form.Encode()
+	if len(encoded) > maxSize {
+		return
+	}
+	session.Values[stashField] = StashedRequest{
+		Method: r.Method,
+		URL:    r.URL.RequestURI(),
+		Form:   encoded,
+		Mode:   mode,
+		Time:   time.Now(),
+	}
+}
+
+// stashed returns the session's StashedRequest, if it's still fresh, or nil.
+func (m *Manager) stashed(session *sessions.Session) *StashedRequest {
+	if stash, ok := session.Values[stashField].(StashedRequest); ok && time.Since(stash.Time) < resumeTimeOut {
+		return &stash
+	}
+	return nil
+}
+
+// resubmit writes the page that resubmits the stashed request, with a fresh
+// token for the new session: a FormToken, or in DoubleSubmitMode, the
+// DoubleSubmitToken().
+func (m *Manager) resubmit(w http.ResponseWriter, r *http.Request, stash *StashedRequest) (ok bool) {
+	values, err := url.ParseQuery(stash.Form)
+	if err != nil {
+		return
+	}
+	target, err := url.Parse(stash.URL)
+	if err != nil {
+		return
+	}
+	var token string
+	if stash.Mode == DoubleSubmitMode {
+		token = m.DoubleSubmitToken(w, r)
+	} else if token, err = m.NewFormToken(r, target.Path).Encode(); err != nil {
+		m.logger().Error("secure: encoding form token failed", "error", err)
+		return
+	}
+	values.Set(FormValueName, token)
+	w.Header().Set("Cache-Control", "no-store")
+	m.current().render(w, ResubmitTemplate, http.StatusOK, &Resubmission{*stash, values})
+	return true
+}
@@ -0,0 +1,111 @@
+package secure
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var hiddenInput = regexp.MustCompile(`<input type="hidden" name="([^"]*)" value="([^"]*)">`)
+
+// resubmission returns the form values of the resubmit page.
+func resubmission(body string) url.Values {
+	values := make(url.Values)
+	for _, match := range hiddenInput.FindAllStringSubmatch(body, -1) {
+		values.Add(html.UnescapeString(match[1]), html.UnescapeString(match[2]))
+	}
+	return values
+}
+
+// postForm posts the form to /form, through the handler, and returns the
+// status code.
+func (c *client) postForm(h http.Handler, form url.Values) int {
+	r := newRequest("POST", "/form", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(h, r).Code
+}
+
+// resumeHandler records the comment of the logged in client's form.
+func resumeHandler(m *Manager, comments *[]string) http.Handler {
+	return m.RequireAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*comments = append(*comments, m.Authentication(r).(testRecord).Name+": "+r.PostFormValue("comment"))
+	}))
+}
+
+func TestResume(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Resume = true
+	})
+	var comments []string
+	h := m.VerifyFormToken(resumeHandler(m, &comments))
+	c := newClient()
+	form := url.Values{"comment": {"hello"}, FormValueName: {c.formToken(m, "/form", "192.0.2.1:1234")}}
+	if code := c.postForm(h, form); code != http.StatusForbidden {
+		t.Fatalf("got %d; want 403", code)
+	}
+	w := c.logIn(t, m, testRecord{"alice"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("LogIn: got %d; want the resubmit page", w.Code)
+	}
+	values := resubmission(w.Body.String())
+	if values.Get("comment") != "hello" || values.Get(FormValueName) == form.Get(FormValueName) {
+		t.Fatalf("got %v; want the form with a fresh token", values)
+	}
+	if code := c.postForm(h, values); code != http.StatusOK {
+		t.Errorf("resubmission: got %d; want 200", code)
+	}
+	if len(comments) != 1 || comments[0] != "alice: hello" {
+		t.Errorf("got %v; want the resumed comment", comments)
+	}
+}
+
+func TestResumeForged(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Resume = true
+	})
+	var comments []string
+	form := url.Values{"comment": {"forged"}}
+	for name, h := range map[string]http.Handler{
+		"without CSRF check": resumeHandler(m, &comments),
+		"invalid token":      m.VerifyFormToken(resumeHandler(m, &comments)),
+	} {
+		c := newClient()
+		c.postForm(h, form)
+		if w := c.logIn(t, m, testRecord{"alice"}); w.Code != http.StatusSeeOther {
+			t.Errorf("%s: got %d; want a redirect instead of a resubmission", name, w.Code)
+		}
+	}
+	if len(comments) != 0 {
+		t.Errorf("got %v", comments)
+	}
+}
+
+func TestResumeDoubleSubmit(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Resume = true
+	})
+	var comments []string
+	h := m.VerifyDoubleSubmit(resumeHandler(m, &comments))
+	c := newClient()
+	form := url.Values{"comment": {"hello"}, FormValueName: {c.doubleSubmitToken(m)}}
+	if code := c.postForm(h, form); code != http.StatusForbidden {
+		t.Fatalf("got %d; want 403", code)
+	}
+	w := c.logIn(t, m, testRecord{"alice"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("LogIn: got %d; want the resubmit page", w.Code)
+	}
+	values := resubmission(w.Body.String())
+	if token := c.doubleSubmitToken(m); values.Get(FormValueName) != token {
+		t.Errorf("got token %q; want the DoubleSubmitToken of the new session %q", values.Get(FormValueName), token)
+	}
+	if code := c.postForm(h, values); code != http.StatusOK {
+		t.Errorf("resubmission: got %d; want 200", code)
+	}
+	if len(comments) != 1 || comments[0] != "alice: hello" {
+		t.Errorf("got %v; want the resumed comment", comments)
+	}
+}
@@ -0,0 +1,71 @@
+package secure
+
+import (
+	"github.com/gorilla/sessions"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+ReturnToName is the name of the form value that a log in page can set to the
+URL to return to after LogIn(). It takes precedence over the URL of the request
+that Handle() sent to the log in page.
+*/
+const ReturnToName = "return_to"
+
+func (s *Session) postLogInPath() string {
+	if s.PostLogInPath != "" {
+		return s.PostLogInPath
+	}
+	return s.LogOutPath
+}
+
+/*
+safeReturn reports whether it's safe to redirect to the target after LogIn():
+either a path on this server, or an https URL on this host, or on one of
+Session.ReturnHosts. Anything else could send the client to a phishing site.
+*/
+func (s *Session) safeReturn(r *http.Request, target string) bool {
+	for _, c := range target {
+		if c < ' ' || c == 0x7f || c == '\\' {
+			return false
+		}
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Opaque != "" || u.User != nil {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(u.Path, "/")
+	}
+	if u.Scheme != "https" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, host := range s.ReturnHosts {
+		if strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// returnTo returns the URL to redirect to after LogIn(): the ReturnToName form
+// value, or else the URL of the request that Handle() rejected, if it's safe,
+// or else Session.PostLogInPath.
+func (m *Manager) returnTo(r *http.Request, session *sessions.Session) string {
+	config := m.session()
+	stored, _ := session.Values[returnField].(string)
+	for _, target := range []string{r.FormValue(ReturnToName), stored} {
+		if target == "" {
+		} else if config.safeReturn(r, target) {
+			return target
+		} else {
+			m.logger().Warn("secure: unsafe return URL rejected", "url", target, "ip", m.token().clientIP(r))
+		}
+	}
+	return config.postLogInPath()
+}
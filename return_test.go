@@ -0,0 +1,99 @@
+package secure
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSafeReturn(t *testing.T) {
+	s := &Session{ReturnHosts: []string{"accounts.example.org"}}
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/search?q=x&page=3", true},
+		{"/", true},
+		{"https://example.com/account", true},
+		{"https://EXAMPLE.com/account", true},
+		{"https://accounts.example.org/profile", true},
+		{"http://example.com/account", false},
+		{"https://evil.example/", false},
+		{"//evil.example/", false},
+		{"/\\evil.example/", false},
+		{"https://user@example.com/", false},
+		{"javascript:alert(1)", false},
+		{"relative/path", false},
+		{"/path\r\nLocation: https://evil.example/", false},
+	}
+	r := newRequest("GET", "/", nil)
+	for _, test := range tests {
+		if got := s.safeReturn(r, test.target); got != test.want {
+			t.Errorf("safeReturn(%q) = %v; want %v", test.target, got, test.want)
+		}
+	}
+}
+
+// returnedTo logs the client in with the return_to form value, if given, and
+// returns the URL that LogIn redirects to.
+func (c *client) returnedTo(t *testing.T, m *Manager, returnTo string) string {
+	t.Helper()
+	r := newRequest("POST", "/session", strings.NewReader(url.Values{ReturnToName: {returnTo}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.LogIn(w, r, testRecord{"alice"}); err != nil {
+			t.Error(err)
+		}
+	}), r)
+	return w.Header().Get("Location")
+}
+
+// rejected has the request for the target rejected, so that it's stored as
+// the URL to return to.
+func (c *client) rejected(m *Manager, target string) {
+	c.do(m.RequireAuthentication(okHandler), newRequest("GET", target, nil))
+}
+
+func TestReturnTo(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.PostLogInPath = "/home"
+	})
+
+	c := newClient()
+	c.rejected(m, "/search?q=x&page=3")
+	if got := c.returnedTo(t, m, ""); got != "/search?q=x&page=3" {
+		t.Errorf("got %q; want the rejected URL with its query", got)
+	}
+	if got := c.returnedTo(t, m, ""); got != "/home" {
+		t.Errorf("got %q on the next LogIn; want PostLogInPath", got)
+	}
+
+	c = newClient()
+	c.rejected(m, "/search")
+	if got := c.returnedTo(t, m, "/orders?id=1"); got != "/orders?id=1" {
+		t.Errorf("got %q; want the return_to value", got)
+	}
+
+	c = newClient()
+	c.rejected(m, "/search")
+	if got := c.returnedTo(t, m, "https://evil.example/"); got != "/search" {
+		t.Errorf("got %q; want the unsafe return_to value skipped", got)
+	}
+
+	if got := newClient().returnedTo(t, m, "//evil.example/"); got != "/home" {
+		t.Errorf("got %q; want PostLogInPath", got)
+	}
+}
+
+func TestReturnHosts(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.ReturnHosts = []string{"shop.example.com"}
+	})
+	if got := newClient().returnedTo(t, m, "https://shop.example.com/cart"); got != "https://shop.example.com/cart" {
+		t.Errorf("got %q; want the allowed host", got)
+	}
+	if got := newClient().returnedTo(t, m, "https://other.example.com/"); got != "/" {
+		t.Errorf("got %q; want LogOutPath", got)
+	}
+}
@@ -1,9 +1,12 @@
 package secure
 
 import (
+	"bytes"
+	"encoding/json"
 	"github.com/gorilla/context"
 	"github.com/julienschmidt/httprouter"
-	"log"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
 )
@@ -11,46 +14,83 @@ import (
 /*
 A SecureRouter is a secured httprouter.
 PUT, POST, PATCH, and DELETE handles check for a valid FormToken encrypted token
-string in the request's "_formtoken" FormValue.
+string in the request's "X-CSRF-Token" header (see Token.HeaderName), in the
+"_formtoken" member of a JSON request body, or in the "_formtoken" FormValue.
+In DoubleSubmitMode, they check for the DoubleSubmitToken() instead.
 */
 type SecureRouter struct {
 	*httprouter.Router
+	manager *Manager
+	mode    CSRFMode
+}
+
+/*
+Router returns the Manager's secure router, in FormTokenMode.
+*/
+func (m *Manager) Router() *SecureRouter {
+	if m.router == nil {
+		m.router = m.NewRouter(FormTokenMode)
+	}
+	return m.router
+}
+
+/*
+NewRouter returns a new secure router for the Manager, in the given CSRFMode.
+*/
+func (m *Manager) NewRouter(mode CSRFMode) *SecureRouter {
+	return &SecureRouter{httprouter.New(), m, mode}
 }
 
 var router *SecureRouter
 
 /*
-Router returns the secure router.
+Router returns the default Manager's secure router, in FormTokenMode.
 */
 func Router() *SecureRouter {
 	if router == nil {
-		router = &SecureRouter{httprouter.New()}
+		router = NewRouter(FormTokenMode)
 	}
 	return router
 }
 
+/*
+NewRouter returns a new secure router for the default Manager, in the given
+CSRFMode.
+*/
+func NewRouter(mode CSRFMode) *SecureRouter {
+	return (*Manager)(nil).NewRouter(mode)
+}
+
+// csrfHandle wraps the handle in the CSRF check for the router's mode.
+func (r *SecureRouter) csrfHandle(handle httprouter.Handle) httprouter.Handle {
+	if r.mode == DoubleSubmitMode {
+		return routerHandle(r.manager.VerifyDoubleSubmit, handle)
+	}
+	return routerHandle(r.manager.VerifyFormToken, handle)
+}
+
 // PUT registers a handler for a PUT request to the given path.
 // The handler is only run if the request carries a valid form token.
 func (r *SecureRouter) PUT(path string, handle httprouter.Handle) {
-	r.Handle("PUT", path, clearHandle(formTokenHandle(handle)))
+	r.Handle("PUT", path, clearHandle(r.csrfHandle(handle)))
 }
 
 // POST registers a handler for a POST request to the given path.
 // The handler is only run if the request carries a valid form token.
 func (r *SecureRouter) POST(path string, handle httprouter.Handle) {
-	r.Handle("POST", path, clearHandle(formTokenHandle(handle)))
+	r.Handle("POST", path, clearHandle(r.csrfHandle(handle)))
 }
 
 // PATCH registers a handler for a PATCH request to the given path.
 // The handler is only run if the request carries a valid form token.
 func (r *SecureRouter) PATCH(path string, handle httprouter.Handle) {
-	r.Handle("PATCH", path, clearHandle(formTokenHandle(handle)))
+	r.Handle("PATCH", path, clearHandle(r.csrfHandle(handle)))
 }
 
 // DELETE registers a handler for a DELETE request to the given path.
 // The handler is only run if the request carries a valid form token.
 func (r *SecureRouter) DELETE(path string, handle httprouter.Handle) {
-	r.Handle("DELETE", path, clearHandle(formTokenHandle(handle)))
+	r.Handle("DELETE", path, clearHandle(r.csrfHandle(handle)))
 }
 
 // GET registers a handler for a GET request to the given path.
@@ -75,89 +115,165 @@ func clearHandle(handle httprouter.Handle) httprouter.Handle {
 	}
 }
 
+// routerHandle wraps the handle in the middleware.
+func routerHandle(middleware func(http.Handler) http.Handler, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handle(w, r, ps)
+		})).ServeHTTP(w, r)
+	}
+}
+
 /*
 FormValueName is the name of the FormValue that is checked in PUT, POST, PATCH,
-and DELETE handles.
+and DELETE handles. It's also the name of the member that is checked in JSON
+request bodies.
 */
 const FormValueName = "_formtoken"
 
-func errorMessage(w http.ResponseWriter, this, that *FormToken, referer *url.URL) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write([]byte(`<!DOCTYPE html>
-		<html>
-			<head>
-				<meta charset="utf-8">
-			</head>
-			<body>
-				<h2>Form token validation failed</h2>
-				<table>
-					<tr>
-						<th></th>
-						<th>IP</th>
-						<th>Path</th>
-					</tr>
-					<tr>
-						<th>this</th>
-						<td>` + this.IP + `</td>
-						<td>` + this.Path + `</td>
-					</tr>
-					<tr>
-						<th>that</th>
-						<td>` + that.IP + `</td>
-						<td>` + that.Path + `</td>
-					</tr>
-				</table>
-				<a id="location" href="` + referer.Path + `">Back</a>
-			</body>
-		</html>
-	`))
-}
-
-func formTokenHandle(handle httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		this, that := NewFormToken(r), new(FormToken)
-		referer, _ := url.Parse(r.Referer())
-		if err := that.Parse(r.FormValue(FormValueName)); err != nil {
-			log.Printf("WARNING: Error parsing form token: %s %s %s", err, this.IP, this.Path)
-			errorMessage(w, this, that, referer)
-		} else if that.IP != this.IP || (that.Path != this.Path && that.Path != referer.Path) {
-			// Timestamp not considered, since key rotation will outdate old tokens automatically
-			log.Printf("WARNING: Form token invalid %s %s", this.IP, this.Path)
-			errorMessage(w, this, that, referer)
+// maxJSONPeek limits how much of a JSON request body is read to find the
+// token.
+const maxJSONPeek = 1 << 20
+
+// formTokenValue returns the encrypted token string from the request's
+// header, JSON body, or form value. The body is left intact for the handle to
+// read.
+func formTokenValue(r *http.Request, headerName string) string {
+	if value := r.Header.Get(headerName); value != "" {
+		return value
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" && r.Body != nil {
+		peek, err := io.ReadAll(io.LimitReader(r.Body, maxJSONPeek))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(peek), r.Body), r.Body}
+		var body struct {
+			FormToken string `json:"_formtoken"`
+		}
+		if err == nil && json.Unmarshal(peek, &body) == nil {
+			return body.FormToken
+		}
+		return ""
+	}
+	return r.FormValue(FormValueName)
+}
+
+// verifyFormToken reports whether the request carries a valid FormToken. If
+// not, it writes the response through Config.TokenInvalid.
+func (m *Manager) verifyFormToken(w http.ResponseWriter, r *http.Request) (valid bool) {
+	this, that := m.NewFormToken(r), &FormToken{manager: m}
+	refererPath := ""
+	if referer, err := url.Parse(r.Referer()); err == nil {
+		refererPath = referer.Path
+	}
+	const title = "Form token validation failed"
+	if err := that.Parse(formTokenValue(r, m.token().headerName())); err != nil {
+		m.logger().Warn("Error parsing form token", "error", err, "ip", this.IP, "path", this.Path)
+		m.event(Event{Type: EventFormTokenRejected, Request: r, Reason: "invalid", Err: err})
+		m.tokenInvalid(w, r, title, "invalid", this, that)
+	} else if token := m.token(); !token.ipMatch(that.IP, this.IP) || that.Session != this.Session ||
+		(that.Path != this.Path && that.Path != refererPath) {
+		m.logger().Warn("Form token invalid", "ip", this.IP, "path", this.Path, "token_ip", that.IP, "token_path", that.Path)
+		m.event(Event{Type: EventFormTokenRejected, Request: r, Reason: "mismatch"})
+		m.tokenInvalid(w, r, title, "mismatch", this, that)
+	} else if that.expired(token) {
+		m.logger().Warn("Form token expired", "ip", this.IP, "path", this.Path, "timestamp", that.Timestamp)
+		m.event(Event{Type: EventFormTokenRejected, Request: r, Reason: "expired"})
+		m.tokenInvalid(w, r, title, "expired", this, that)
+	} else if fresh, err := m.useFormToken(token, that); !fresh {
+		if err != nil {
+			m.logger().Error("Form token nonce store failed", "error", err)
+		}
+		m.logger().Warn("Form token reused", "ip", this.IP, "path", this.Path)
+		m.event(Event{Type: EventFormTokenRejected, Request: r, Reason: "replayed", Err: err})
+		m.tokenInvalid(w, r, title, "replayed", this, that)
+	} else {
+		valid = true
+	}
+	return
+}
+
+/*
+TokenHandle serves a fresh encrypted token string to JavaScript clients, as
+JSON: {"_formtoken": "..."}. The token is also set in the response header
+named by Token.HeaderName.
+
+The token is valid for the path in the "path" query parameter, or else for the
+page that requested it (the Referer).
+*/
+func (m *Manager) TokenHandle(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	m = m.orDefault()
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		if referer, err := url.Parse(r.Referer()); err == nil && referer.Path != "" {
+			path = referer.Path
 		} else {
-			handle(w, r, ps)
+			path = "/"
 		}
 	}
+	token, err := m.NewFormToken(r, path).Encode()
+	if err != nil {
+		m.logger().Error("secure: encoding form token failed", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeToken(w, m.token().headerName(), token)
+}
+
+func writeToken(w http.ResponseWriter, headerName, token string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(headerName, token)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{FormValueName: token})
 }
 
 /*
-secure.Handle ensures the client is logged in when accessing a certian route,
+TokenHandle calls TokenHandle on the default Manager.
+*/
+func TokenHandle(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	(*Manager)(nil).TokenHandle(w, r, ps)
+}
+
+/*
+Handle ensures the client is logged in when accessing a certian route,
 redirecting to the log in page if not. The given Handle function should call
 Authentication() to get the client's account details.
 
 If the cookie is missing, the session has timed out, or the cookie data is
-invalidated though the ValidateCookie function, the response then gets status
-403 Forbidden, and the browser will redirect to config.LogInPath.
+invalidated though the ValidateCookie function, the response is written by
+Config.Unauthenticated: by default, browsers get status 403 Forbidden, and
+redirect to config.LogInPath; clients that prefer JSON get status 401
+Unauthorized, with a Problem.
+*/
+func (m *Manager) Handle(handle httprouter.Handle) httprouter.Handle {
+	return routerHandle(m.RequireAuthentication, handle)
+}
+
+/*
+Handle calls Handle on the default Manager.
 */
 func Handle(handle httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		if authenticate(w, r, false) {
-			handle(w, r, ps)
-		}
-	}
+	return (*Manager)(nil).Handle(handle)
 }
 
 /*
-secure.IfHandle calls the one Handle function for logged-in clients, and the
-other for logged-out clients.
+IfHandle calls the one Handle function for logged-in clients, and the other for
+logged-out clients.
 */
-func IfHandle(authenticatedHandle httprouter.Handle, unauthenticatedHandle httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		if authenticate(w, r, true) {
+func (m *Manager) IfHandle(authenticatedHandle httprouter.Handle, unauthenticatedHandle httprouter.Handle) httprouter.Handle {
+	return routerHandle(m.OptionalAuthentication, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if m.orDefault().Authenticated(r) {
 			authenticatedHandle(w, r, ps)
 		} else {
 			unauthenticatedHandle(w, r, ps)
 		}
-	}
+	})
+}
+
+/*
+IfHandle calls IfHandle on the default Manager.
+*/
+func IfHandle(authenticatedHandle httprouter.Handle, unauthenticatedHandle httprouter.Handle) httprouter.Handle {
+	return (*Manager)(nil).IfHandle(authenticatedHandle, unauthenticatedHandle)
 }
@@ -0,0 +1,82 @@
+package secure
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFormTokenValue(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	token := c.formToken(m, "/form", "192.0.2.1:1234")
+	var body string
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	})
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		header      string
+	}{
+		{"header", "text/plain", "payload", token},
+		{"JSON", "application/json", `{"_formtoken":"` + token + `","name":"value"}`, ""},
+		{"form", "application/x-www-form-urlencoded", url.Values{FormValueName: {token}}.Encode(), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRequest("POST", "/form", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			if test.header != "" {
+				r.Header.Set(defaultHeaderName, test.header)
+			}
+			body = ""
+			if code := c.do(m.VerifyFormToken(echo), r).Code; code != http.StatusOK {
+				t.Fatalf("got %d; want 200", code)
+			}
+			if test.contentType != "application/x-www-form-urlencoded" && body != test.body {
+				t.Errorf("handler read %q; want the body intact", body)
+			}
+		})
+	}
+}
+
+func TestHeaderName(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Token.HeaderName = "X-XSRF-Token"
+	})
+	c := newClient()
+	r := newRequest("POST", "/form", nil)
+	r.Header.Set("X-XSRF-Token", c.formToken(m, "/form", "192.0.2.1:1234"))
+	if code := c.do(m.VerifyFormToken(okHandler), r).Code; code != http.StatusOK {
+		t.Errorf("got %d; want 200", code)
+	}
+}
+
+func TestTokenHandle(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	r := newRequest("GET", "/token?path=/form", nil)
+	w := c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.TokenHandle(w, r, nil)
+	}), r)
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	token := body[FormValueName]
+	if token == "" || w.Header().Get(defaultHeaderName) != token {
+		t.Fatalf("got body %v, header %q", body, w.Header().Get(defaultHeaderName))
+	}
+	if cache := w.Header().Get("Cache-Control"); cache != "no-store" {
+		t.Errorf("got Cache-Control %q; want no-store", cache)
+	}
+	if code := c.postToken(m, "/form", token, r.RemoteAddr); code != http.StatusOK {
+		t.Errorf("got %d; want 200", code)
+	}
+}
@@ -10,6 +10,11 @@ configuration parameters are stored in a 'Config' type struct. The 'DB'
 interface syncs the Config to an external database, and automatically rotates
 security keys.
 
+To run several independent configurations in one process, call 'New()' for
+each of them; the resulting 'Manager' values offer the same functionality as
+the package level functions, which delegate to the Manager that 'Configure()'
+created.
+
 Once configured, call 'Authentication()' to retrieve the data from the cookie.
 It will redirect to a login page if no valid cookie is present (unless the
 'optional' argument was 'true'). 'LogIn()' creates a new cookie, stores the
@@ -18,16 +23,26 @@ authentication.
 'Update()' updates the authentication data in the current cookie. 'LogOut()'
 deletes the cookie.
 
-You'll probably want to wrap 'Authentication()' in a function that converts the
-'interface{}' result to the type that you use for the cookie data.
+Call 'NewTyped()' to get a 'TypedManager' that takes and returns the type that
+you use for the cookie data, instead of 'interface{}'.
+
+The 'Router()' is an httprouter that checks form tokens. For any other router,
+or http.ServeMux, wrap the handlers in the 'RequireAuthentication',
+'OptionalAuthentication', 'VerifyFormToken', or 'VerifyDoubleSubmit'
+middleware.
 */
 package secure
 
 import (
+	"bytes"
+	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"github.com/gorilla/securecookie"
-	"log"
+	"html/template"
+	"reflect"
+	"sync/atomic"
 	"time"
 )
 
@@ -39,6 +54,17 @@ var (
 	// ErrNoTLS is returned by LogIn() if the connection isn't encrypted
 	// (https)
 	ErrNoTLS = errors.New("secure: logging in requires an encrypted conection")
+
+	// ErrSync is wrapped by the errors that syncing with the DB returns.
+	ErrSync = errors.New("secure: syncing with the DB failed")
+
+	// ErrRecordType is returned by Configure(), New(), and NewTyped() if the
+	// record is nil, e.g. for an interface type; its type can't be registered.
+	ErrRecordType = errors.New("secure: the record must have a concrete type")
+
+	// ErrCookieName is returned by Configure(), New(), and NewTyped() if
+	// Session.Cookie.Name isn't a valid cookie name, e.g. if it has a space.
+	ErrCookieName = errors.New("secure: invalid cookie name")
 )
 
 const (
@@ -57,33 +83,64 @@ type Keys struct {
 
 	// TimeOut is how much time Start the key pairs should be rotated.
 	TimeOut time.Duration
+
+	// local marks keys that were rotated locally, and not synced with the DB
+	// yet. They aren't rotated again until the DB has them.
+	local bool
 }
 
 func (k *Keys) stale() bool {
-	return time.Since(k.Start) >= k.TimeOut
+	return !k.local && time.Since(k.Start) >= k.TimeOut
 }
 
-func (k *Keys) rotate() {
-	k.KeyPairs = [][]byte{
-		k.KeyPairs[4],
-		k.KeyPairs[5],
-		k.KeyPairs[0],
-		k.KeyPairs[1],
-		securecookie.GenerateRandomKey(authKeyLen),
-		securecookie.GenerateRandomKey(encrKeyLen),
+// rotated returns new Keys, with the next key pair as the current one. Keys
+// are never modified in place, since they're read concurrently.
+//
+// 'current' is nil for a local rotation. Otherwise, it's the Manager's
+// current Keys; if those are the local rotation of k, they're adopted, so
+// that the cookies and tokens they created stay valid.
+func (k *Keys) rotated(current *Keys) *Keys {
+	if current != nil && current.local && current.follows(k) {
+		return &Keys{KeyPairs: current.KeyPairs, Start: current.Start, TimeOut: k.TimeOut}
+	}
+	return &Keys{
+		KeyPairs: [][]byte{
+			k.KeyPairs[4],
+			k.KeyPairs[5],
+			k.KeyPairs[0],
+			k.KeyPairs[1],
+			securecookie.GenerateRandomKey(authKeyLen),
+			securecookie.GenerateRandomKey(encrKeyLen),
+		},
+		Start:   time.Now(),
+		TimeOut: k.TimeOut,
+		local:   current == nil,
 	}
-	k.Start = time.Now()
 }
 
-func (k *Keys) freshen() {
-	if k.stale() {
-		k.rotate()
-		go sync()
+// follows reports whether the keys are the rotation of the given keys.
+func (k *Keys) follows(previous *Keys) bool {
+	if len(k.KeyPairs) != 6 || len(previous.KeyPairs) != 6 {
+		return false
+	}
+	for i := 0; i < 4; i++ {
+		if !bytes.Equal(k.KeyPairs[i], previous.KeyPairs[(i+4)%6]) {
+			return false
+		}
 	}
+	return true
+}
+
+// untilStale returns the time left until the keys should be rotated.
+func (k *Keys) untilStale() time.Duration {
+	return k.TimeOut - time.Since(k.Start)
 }
 
 // Config holds the package's configuration parameters.
 // Can be synced with an external database, through the DB interface.
+//
+// A Config that is in use by a Manager must not be modified; changes are
+// published as a new Config, either through the DB, or by key rotation.
 type Config struct {
 
 	// Session manages session cryptography.
@@ -91,6 +148,119 @@ type Config struct {
 
 	// Token manages token cryptography.
 	Token *Token
+
+	// OnSyncError gets called when syncing with the DB fails in the
+	// background. Syncing is then retried, with increasing intervals.
+	// If nil, the error is logged.
+	// OnSyncError is not synced with the DB.
+	OnSyncError func(err error) `json:"-"`
+
+	// Logger receives the package's log output.
+	// Default value is slog.Default().
+	// Logger is not synced with the DB.
+	Logger Logger `json:"-"`
+
+	// OnEvent gets called for each security Event, e.g. to feed an audit log.
+	// It's called synchronously, from the request's goroutine.
+	// OnEvent is not synced with the DB.
+	OnEvent func(e Event) `json:"-"`
+
+	// Unauthenticated writes the response when Handle() rejects a client that
+	// isn't logged in. The Problem's Location is the Session.LogInPath.
+	// Default value is a handler that writes a Problem as JSON, with status 401
+	// Unauthorized, to clients that prefer JSON, and a page that redirects to
+	// the log in page, with status 403 Forbidden, to browsers.
+	// Unauthenticated is not synced with the DB.
+	Unauthenticated ProblemHandler `json:"-"`
+
+	// Forbidden writes the response of Forbidden().
+	// Default value is a handler that writes a Problem as JSON to clients that
+	// prefer JSON, and an HTML page to browsers.
+	// Forbidden is not synced with the DB.
+	Forbidden ProblemHandler `json:"-"`
+
+	// TokenInvalid writes the response when a FormToken or double submit token
+	// fails, with status 400 Bad Request.
+	// Default value is a handler that writes a Problem as JSON to clients that
+	// prefer JSON, and an HTML page to browsers.
+	// TokenInvalid is not synced with the DB.
+	TokenInvalid ProblemHandler `json:"-"`
+
+	// Templates overrides the built-in pages; define any of the templates
+	// named UnauthenticatedTemplate, ForbiddenTemplate, TokenInvalidTemplate
+	// (which get the *Problem as their data), and ResubmitTemplate.
+	// Templates is not synced with the DB.
+	Templates *template.Template `json:"-"`
+
+	// Production hides the diagnostic details, like the Problem's Reason and
+	// FormTokens, from the responses; they're still logged.
+	// Default value is false.
+	// Production is not synced with the DB.
+	Production bool `json:"-"`
+}
+
+// inherit copies the settings that aren't synced with the DB from the given
+// Config.
+func (c *Config) inherit(from *Config) {
+	c.OnSyncError = from.OnSyncError
+	c.Logger = from.Logger
+	c.OnEvent = from.OnEvent
+	c.Unauthenticated = from.Unauthenticated
+	c.Forbidden = from.Forbidden
+	c.TokenInvalid = from.TokenInvalid
+	c.Templates = from.Templates
+	c.Production = from.Production
+	c.Token.Nonces = from.Token.Nonces
+	c.Session.Store = from.Session.Store
+	c.Session.UserKey = from.Session.UserKey
+	c.Session.Epochs = from.Session.Epochs
+	c.Session.Denylist = from.Session.Denylist
+	c.Session.Remember = from.Session.Remember
+	c.Session.Serializer = from.Session.Serializer
+	c.Session.Migrate = from.Session.Migrate
+	c.Token.Serializer = from.Token.Serializer
+}
+
+// rotated returns a copy of the Config with the stale keys rotated, or nil if
+// no keys are stale. 'current' is nil for a local rotation, or else the
+// Manager's current Config; see Keys.rotated().
+func (c *Config) rotated(current *Config) (fresh *Config) {
+	var currentSession, currentToken *Keys
+	if current != nil {
+		currentSession, currentToken = current.Session.Keys, current.Token.Keys
+	}
+	session, token := c.Session, c.Token
+	if session.stale() {
+		s := *session
+		s.Keys = session.rotated(currentSession)
+		session = &s
+	}
+	if token.stale() {
+		t := *token
+		t.Keys = token.rotated(currentToken)
+		token = &t
+	}
+	if session != c.Session || token != c.Token {
+		f := *c
+		f.Session, f.Token = session, token
+		fresh = &f
+	}
+	return
+}
+
+// init prepares the Config for concurrent use, before it gets published.
+func (c *Config) init() error {
+	c.Session.init()
+	return c.Token.init()
+}
+
+// untilStale returns the time left until any of the keys should be rotated.
+func (c *Config) untilStale() (d time.Duration) {
+	d = c.Session.untilStale()
+	if t := c.Token.untilStale(); t < d {
+		d = t
+	}
+	return
 }
 
 // DB is the interface to implement for syncing the configuration parameters.
@@ -109,40 +279,6 @@ type DB interface {
 	Upsert(src *Config) error
 }
 
-func sync() {
-	dbConfig := new(Config)
-	if err := db.Fetch(dbConfig); err != nil {
-		// Upload current (default) config to DB if there wasn't any
-		if err := db.Upsert(config); err != nil {
-			log.Panicln("ERROR: secure DB: saving default config failed:", err)
-		}
-	} else {
-		// Replace current config with the one from DB
-		config = dbConfig
-		// Rotate keys if timed out
-		rotate := false
-		if config.Session.stale() {
-			rotate = true
-			config.Session.rotate()
-			log.Println("INFO: secure DB: rotating session keys...")
-		}
-		if config.Token.stale() {
-			rotate = true
-			config.Token.rotate()
-			log.Println("INFO: secure DB: rotating token keys...")
-		}
-		if rotate {
-			if err := db.Upsert(config); err != nil {
-				log.Panicln("ERROR: secure DB: key rotatation failed:", err)
-			} else {
-				log.Println("INFO: secure DB: keys rotated")
-			}
-		}
-	}
-	tokenKeys = config.Token
-	sessionKeys = config.Session
-}
-
 // ValidateCookie is the type of the function passed to Configure(), that gets called
 // to have the application test whether the cookie data is still valid (e.g. to
 // prevent continued access with a cookie that was created with an old password)
@@ -161,10 +297,8 @@ func sync() {
 // validation timestamp > config.SyncInterval
 type ValidateCookie func(src interface{}) (dst interface{}, valid bool)
 
-var (
-	db       DB
-	validate ValidateCookie
-	config   = &Config{
+func defaultConfig() *Config {
+	return &Config{
 		Session: &Session{
 			Keys: &Keys{
 				KeyPairs: [][]byte{
@@ -180,6 +314,7 @@ var (
 			},
 			LogInPath:       "/session",
 			LogOutPath:      "/",
+			AbsoluteTimeOut: defaultAbsoluteTimeOut,
 			ValidateTimeOut: 5 * time.Minute,
 		},
 		Token: &Token{
@@ -197,17 +332,218 @@ var (
 			},
 		},
 	}
-	tokenKeys   *Token
-	sessionKeys *Session
+}
+
+/*
+A Manager manages the authentication cookies and form tokens for one
+configuration. It owns its Config, DB, ValidateCookie function, session cookie
+store, and token codecs.
+*/
+type Manager struct {
+	db         DB
+	validate   ValidateCookie
+	recordType reflect.Type
+	config     atomic.Pointer[Config]
+	wake       chan struct{}
+	cancel     context.CancelFunc
+	done       chan struct{}
+	nonces     NonceStore
+	epochs     EpochSource
+	denied     Denylist
+	series     RememberStore
+	router     *SecureRouter
+}
+
+// manager is the default Manager, used by the package level functions.
+var manager *Manager
+
+// orDefault returns m, or the default Manager if m is nil. Handles created
+// through the package level functions resolve the default Manager when they
+// run, so they can be set up before calling Configure().
+func (m *Manager) orDefault() *Manager {
+	if m == nil {
+		return manager
+	}
+	return m
+}
+
+// New creates a Manager. The arguments are the same as for Configure().
+func New(ctx context.Context, record interface{}, dbImpl DB, validateFunc ValidateCookie, opt_config ...*Config) (m *Manager, err error) {
+	if record == nil {
+		return nil, ErrRecordType
+	}
+	gob.Register(record)
+	gob.Register(time.Now())
+	m = &Manager{
+		db:         dbImpl,
+		validate:   validateFunc,
+		recordType: reflect.TypeOf(record),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		nonces:     NewMemoryNonceStore(defaultNonceCapacity),
+		epochs:     NewMemoryEpochSource(),
+		denied:     NewMemoryDenylist(),
+		series:     NewMemoryRememberStore(),
+	}
+	config := defaultConfig()
+	if len(opt_config) == 1 {
+		config = opt_config[0]
+	}
+	if err = config.Session.Cookie.validate(); err != nil {
+		return nil, err
+	}
+	m.publish(config)
+	if err = m.sync(); err != nil {
+		return nil, err
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	go m.loop(ctx)
+	return
+}
+
+// Stop terminates the Manager's background syncing with the DB. The Manager
+// keeps working with its current Config.
+func (m *Manager) Stop() {
+	m.cancel()
+	<-m.done
+}
+
+const (
+	// minSyncInterval limits the sync frequency when keys are (almost) stale.
+	minSyncInterval = time.Second
+
+	// maxSyncBackoff limits the retry interval after failing syncs.
+	maxSyncBackoff = 5 * time.Minute
 )
 
+// loop runs sync whenever any of the keys are due for rotation, or when woken
+// up after a local key rotation. It's the only goroutine that calls sync, once
+// New() has returned. Failing syncs are retried with exponential backoff.
+func (m *Manager) loop(ctx context.Context) {
+	defer close(m.done)
+	var backoff time.Duration
+	for {
+		wait, wake := backoff, m.wake
+		if backoff > 0 {
+			// Don't let local key rotations override the backoff
+			wake = nil
+		} else if wait = m.config.Load().untilStale(); wait < minSyncInterval {
+			wait = minSyncInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+		}
+		if err := m.sync(); err == nil {
+			backoff = 0
+		} else {
+			if backoff *= 2; backoff < minSyncInterval {
+				backoff = minSyncInterval
+			} else if backoff > maxSyncBackoff {
+				backoff = maxSyncBackoff
+			}
+			m.event(Event{Type: EventSyncFailure, Err: err})
+			if onSyncError := m.config.Load().OnSyncError; onSyncError != nil {
+				onSyncError(err)
+			} else {
+				m.logger().Error("secure DB: sync failed", "error", err, "retry", backoff)
+			}
+		}
+	}
+}
+
+func (m *Manager) sync() error {
+	current := m.config.Load()
+	config := current
+	dbConfig := new(Config)
+	if err := m.db.Fetch(dbConfig); err != nil {
+		// Upload current (default) config to DB if there wasn't any
+		if err := m.db.Upsert(config); err != nil {
+			return fmt.Errorf("%w: saving default config: %w", ErrSync, err)
+		}
+	} else if err := dbConfig.Session.Cookie.validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrSync, err)
+	} else {
+		// Replace current config with the one from DB
+		dbConfig.inherit(config)
+		config = dbConfig
+		// Rotate keys if timed out
+		if fresh := config.rotated(current); fresh != nil {
+			var rotated []string
+			if fresh.Session != config.Session {
+				rotated = append(rotated, "session")
+			}
+			if fresh.Token != config.Token {
+				rotated = append(rotated, "token")
+			}
+			m.logger().Info("secure DB: rotating keys...", "keys", rotated)
+			if err := m.db.Upsert(fresh); err != nil {
+				// Carry on with the DB's keys; they'll get rotated locally
+				m.publish(config)
+				return fmt.Errorf("%w: key rotation: %w", ErrSync, err)
+			}
+			m.logger().Info("secure DB: keys rotated")
+			for _, keys := range rotated {
+				m.event(Event{Type: EventKeyRotation, Reason: keys})
+			}
+			config = fresh
+		}
+	}
+	m.publish(config)
+	return nil
+}
+
+// publish makes the given Config the current one.
+func (m *Manager) publish(config *Config) {
+	config.Session.recordType = m.recordType
+	if err := config.init(); err != nil {
+		config.logger().Warn("secure: configuration error", "error", err)
+	}
+	m.config.Store(config)
+}
+
+// current returns the current Config, after rotating any stale keys. A local
+// rotation wakes up the sync loop to have the DB rotate the keys.
+func (m *Manager) current() *Config {
+	config := m.config.Load()
+	if fresh := config.rotated(nil); fresh != nil {
+		_ = fresh.init()
+		if m.config.CompareAndSwap(config, fresh) {
+			select {
+			case m.wake <- struct{}{}:
+			default:
+			}
+		}
+		config = m.config.Load()
+	}
+	return config
+}
+
+// session returns the current session configuration.
+func (m *Manager) session() *Session {
+	return m.current().Session
+}
+
+// token returns the current token configuration.
+func (m *Manager) token() *Token {
+	return m.current().Token
+}
+
 // Configure configures the package and must be called once before calling any
 // other function in this package.
 //
+// 'ctx' controls the background syncing with the DB; it stops when ctx is
+// done, or when Stop() is called.
+//
 // 'record' is an arbitrary (can be empty) instance of the type of the
 // authentication data that will be passed to Login() to store in the cookie.
 // It's needed to get its type registered with the serialisation package used
-// (encoding/gob).
+// (encoding/gob), and to restore its type with a custom Session.Serializer.
 //
 // 'dbImpl' is the implementation of the DB interface to sync the configuration
 // and rotate the keys.
@@ -216,29 +552,18 @@ var (
 //
 // 'opt_config' is the Config instance to start with. If omitted, the config
 // from the db is used, or else the default config.
-func Configure(record interface{}, dbImpl DB, validateFunc ValidateCookie, opt_config ...*Config) {
-	gob.Register(record)
-	gob.Register(time.Now())
-	db = dbImpl
-	validate = validateFunc
-	if len(opt_config) == 1 {
-		config = opt_config[0]
+//
+// The error is ErrRecordType if 'record' is nil, ErrCookieName if the cookie
+// name is invalid, or else the result of the initial sync with the DB.
+func Configure(ctx context.Context, record interface{}, dbImpl DB, validateFunc ValidateCookie, opt_config ...*Config) (err error) {
+	m, err := New(ctx, record, dbImpl, validateFunc, opt_config...)
+	if err == nil {
+		manager = m
 	}
-	sync()
-	go func() {
-		time.Sleep(sessionKeys.TimeOut / 2)
-		for {
-			sync()
-			// will the timeout get reread each iteration, to cater for updates?
-			time.Sleep(sessionKeys.TimeOut)
-		}
-	}()
-	go func() {
-		time.Sleep(tokenKeys.TimeOut / 2)
-		for {
-			sync()
-			// will the timeout get reread each iteration, to cater for updates?
-			time.Sleep(tokenKeys.TimeOut)
-		}
-	}()
+	return
+}
+
+// Stop stops the default Manager's background syncing with the DB.
+func Stop() {
+	manager.Stop()
 }
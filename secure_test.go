@@ -0,0 +1,354 @@
+package secure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	gorilla "github.com/gorilla/context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testRecord struct {
+	Name string
+}
+
+var errNoConfig = errors.New("no config")
+
+// memDB is a DB that stores the Config as JSON, like a database would.
+type memDB struct {
+	mu     sync.Mutex
+	config []byte
+}
+
+func (db *memDB) Fetch(dst *Config) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.config == nil {
+		return errNoConfig
+	}
+	return json.Unmarshal(db.config, dst)
+}
+
+func (db *memDB) Upsert(src *Config) (err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.config, err = json.Marshal(src)
+	return
+}
+
+func validRecord(src interface{}) (interface{}, bool) {
+	return src, true
+}
+
+// newTestManager returns a Manager with the default Config, modified by the
+// given functions.
+func newTestManager(t testing.TB, opts ...func(*Config)) *Manager {
+	t.Helper()
+	config := defaultConfig()
+	for _, opt := range opts {
+		opt(config)
+	}
+	m, err := New(context.Background(), testRecord{}, &memDB{}, validRecord, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Stop)
+	return m
+}
+
+// newRequest returns an https request.
+func newRequest(method, target string, body io.Reader) *http.Request {
+	return httptest.NewRequest(method, "https://example.com"+target, body)
+}
+
+// client keeps the cookies between requests, like a browser.
+type client struct {
+	cookies map[string]*http.Cookie
+}
+
+func newClient() *client {
+	return &client{cookies: make(map[string]*http.Cookie)}
+}
+
+func (c *client) do(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	for _, cookie := range c.cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	gorilla.Clear(r)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+// logIn logs the client in with the record.
+func (c *client) logIn(t testing.TB, m *Manager, record interface{}, opts ...LogInOption) *httptest.ResponseRecorder {
+	t.Helper()
+	return c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.LogIn(w, r, record, opts...); err != nil {
+			t.Error(err)
+		}
+	}), newRequest("POST", "/session", nil))
+}
+
+// authentication returns the client's authentication record, and whether it's
+// logged in.
+func (c *client) authentication(m *Manager) (record interface{}, ok bool) {
+	c.do(m.OptionalAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record, ok = m.Authentication(r), m.Authenticated(r)
+	})), newRequest("GET", "/", nil))
+	return
+}
+
+func TestManagersAreIndependent(t *testing.T) {
+	a, b := newTestManager(t), newTestManager(t)
+	c := newClient()
+	c.logIn(t, a, testRecord{"alice"})
+	if record, ok := c.authentication(a); !ok || record != (testRecord{"alice"}) {
+		t.Fatalf("Manager a: got %v, %v; want alice, true", record, ok)
+	}
+	if _, ok := c.authentication(b); ok {
+		t.Fatal("Manager b accepted Manager a's session")
+	}
+}
+
+func TestManagersShareRequest(t *testing.T) {
+	a, b := newTestManager(t), newTestManager(t)
+	c := newClient()
+	c.logIn(t, a, testRecord{"alice"})
+	var aOK, bOK bool
+	var bRecord interface{}
+	c.do(a.OptionalAuthentication(b.OptionalAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aOK, bOK, bRecord = a.Authenticated(r), b.Authenticated(r), b.Authentication(r)
+	}))), newRequest("GET", "/", nil))
+	if !aOK {
+		t.Error("Manager a rejected its session")
+	}
+	if bOK || bRecord != nil {
+		t.Errorf("Manager b accepted Manager a's session: %v", bRecord)
+	}
+}
+
+func TestDefaultManager(t *testing.T) {
+	// Handles resolve the default Manager when they run
+	var record interface{}
+	handler := OptionalAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record = Authentication(r)
+	}))
+	if err := Configure(context.Background(), testRecord{}, &memDB{}, validRecord); err != nil {
+		t.Fatal(err)
+	}
+	defer Stop()
+	c := newClient()
+	c.logIn(t, manager, testRecord{"bob"})
+	c.do(handler, newRequest("GET", "/", nil))
+	if record != (testRecord{"bob"}) {
+		t.Fatalf("got %v; want bob", record)
+	}
+}
+
+func TestConcurrentRotation(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.TimeOut = 250 * time.Millisecond
+		config.Token.TimeOut = 250 * time.Millisecond
+	})
+	deadline := time.Now().Add(time.Second)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				c := newClient()
+				start := time.Now()
+				c.logIn(t, m, testRecord{"alice"})
+				_, ok := c.authentication(m)
+				r := newRequest("GET", "/", nil)
+				err := (&FormToken{manager: m}).Parse(m.NewFormToken(r).String())
+				// Unless the keys were rotated twice in the meantime
+				if time.Since(start) >= 250*time.Millisecond {
+					continue
+				}
+				if !ok {
+					t.Error("session rejected")
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if config := m.config.Load(); time.Since(config.Session.Start) >= 500*time.Millisecond {
+		t.Error("session keys weren't rotated")
+	}
+}
+
+func TestSyncAdoptsLocalRotation(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Token.TimeOut = 50 * time.Millisecond
+	})
+	m.Stop()
+	time.Sleep(60 * time.Millisecond)
+	local := m.token().Keys
+	if !local.local {
+		t.Fatal("keys weren't rotated locally")
+	}
+	token := m.NewFormToken(newRequest("GET", "/", nil)).String()
+	time.Sleep(60 * time.Millisecond)
+	if m.token().Keys != local {
+		t.Fatal("locally rotated keys were rotated again")
+	}
+	if err := m.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if synced := m.config.Load().Token.Keys; synced.local || !bytes.Equal(synced.KeyPairs[4], local.KeyPairs[4]) {
+		t.Fatal("sync didn't adopt the locally rotated keys")
+	}
+	if err := (&FormToken{manager: m}).Parse(token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rotate has the DB rotate all keys, as if they timed out.
+func rotate(t *testing.T, m *Manager) {
+	t.Helper()
+	db := m.db.(*memDB)
+	config := new(Config)
+	if err := db.Fetch(config); err != nil {
+		t.Fatal(err)
+	}
+	config.Session.Start = config.Session.Start.Add(-config.Session.TimeOut)
+	config.Token.Start = config.Token.Start.Add(-config.Token.TimeOut)
+	if err := db.Upsert(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	m := newTestManager(t)
+	m.Stop()
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	token := m.NewFormToken(newRequest("GET", "/", nil)).String()
+
+	rotate(t, m)
+	if _, ok := c.authentication(m); !ok {
+		t.Error("session rejected after one rotation")
+	}
+	if err := (&FormToken{manager: m}).Parse(token); err != nil {
+		t.Errorf("form token rejected after one rotation: %v", err)
+	}
+
+	rotate(t, m)
+	if _, ok := c.authentication(m); ok {
+		t.Error("session accepted after two rotations")
+	}
+	if err := (&FormToken{manager: m}).Parse(token); err == nil {
+		t.Error("form token accepted after two rotations")
+	}
+}
+
+func TestRotationSignsWithNewKeys(t *testing.T) {
+	m := newTestManager(t)
+	m.Stop()
+	rotate(t, m)
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	token := m.NewFormToken(newRequest("GET", "/", nil)).String()
+
+	// Signed with the new current keys, so they survive the next rotation
+	rotate(t, m)
+	if _, ok := c.authentication(m); !ok {
+		t.Error("session rejected")
+	}
+	if err := (&FormToken{manager: m}).Parse(token); err != nil {
+		t.Errorf("form token rejected: %v", err)
+	}
+}
+
+var errDB = errors.New("db down")
+
+// flakyDB is a memDB that fails while 'down' is set.
+type flakyDB struct {
+	memDB
+	down atomic.Bool
+}
+
+func (db *flakyDB) Fetch(dst *Config) error {
+	if db.down.Load() {
+		return errDB
+	}
+	return db.memDB.Fetch(dst)
+}
+
+func (db *flakyDB) Upsert(src *Config) error {
+	if db.down.Load() {
+		return errDB
+	}
+	return db.memDB.Upsert(src)
+}
+
+func TestConfigureError(t *testing.T) {
+	db := new(flakyDB)
+	db.down.Store(true)
+	_, err := New(context.Background(), testRecord{}, db, validRecord)
+	if !errors.Is(err, ErrSync) || !errors.Is(err, errDB) {
+		t.Fatalf("got %v; want ErrSync wrapping the DB's error", err)
+	}
+}
+
+func TestSyncError(t *testing.T) {
+	db := new(flakyDB)
+	config := defaultConfig()
+	config.Token.TimeOut = 10 * time.Millisecond
+	errs := make(chan error, 1)
+	config.OnSyncError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	m, err := New(context.Background(), testRecord{}, db, validRecord, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.down.Store(true)
+	time.Sleep(20 * time.Millisecond)
+	// A local rotation wakes up the sync loop
+	m.current()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrSync) {
+			t.Errorf("got %v; want ErrSync", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("OnSyncError wasn't called")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		m.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("Stop didn't return")
+	}
+}
@@ -0,0 +1,235 @@
+package secure
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"github.com/gorilla/securecookie"
+	"io"
+	"reflect"
+	"time"
+)
+
+/*
+SessionData is the layout of the session data that a Session.Serializer gets to
+encode, instead of the map[interface{}]interface{} of sessions.Session.Values,
+which serializers like JSON can't handle. See the README for the cookie format.
+*/
+type SessionData struct {
+
+	// Record is the authentication data that LogIn() stored.
+	Record interface{} `json:"record,omitempty"`
+
+	// ID identifies the log in; it's empty if the client isn't logged in.
+	ID string `json:"id,omitempty"`
+
+	// Created is the time of LogIn().
+	Created time.Time `json:"created,omitempty"`
+
+	// Validated is when Record was last checked by the ValidateCookie function.
+	Validated time.Time `json:"validated,omitempty"`
+
+	// Active is the time of the last authenticated request.
+	Active time.Time `json:"active,omitempty"`
+
+	// User is the user's key from Session.UserKey.
+	User string `json:"user,omitempty"`
+
+	// Epoch is the user's epoch at LogIn().
+	Epoch uint64 `json:"epoch,omitempty"`
+
+	// Return is the URL to redirect to after LogIn().
+	Return string `json:"return,omitempty"`
+
+	// Stash is the request to resume after LogIn(); see Session.Resume.
+	Stash *StashedRequest `json:"stash,omitempty"`
+
+	// Values holds any other session values, e.g. flash messages.
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+var errSessionKey = errors.New("secure: session value key is not a string")
+
+// sessionData converts the session values to a SessionData.
+func sessionData(values map[interface{}]interface{}) (data *SessionData, err error) {
+	data = new(SessionData)
+	for key, value := range values {
+		switch key {
+		case recordField:
+			data.Record = value
+		case idField:
+			data.ID, _ = value.(string)
+		case createdField:
+			data.Created, _ = value.(time.Time)
+		case validatedField:
+			data.Validated, _ = value.(time.Time)
+		case activeField:
+			data.Active, _ = value.(time.Time)
+		case userField:
+			data.User, _ = value.(string)
+		case epochField:
+			data.Epoch, _ = value.(uint64)
+		case returnField:
+			data.Return, _ = value.(string)
+		case stashField:
+			if stash, ok := value.(StashedRequest); ok {
+				data.Stash = &stash
+			}
+		default:
+			name, ok := key.(string)
+			if !ok {
+				return nil, errSessionKey
+			}
+			if data.Values == nil {
+				data.Values = make(map[string]interface{})
+			}
+			data.Values[name] = value
+		}
+	}
+	return
+}
+
+// values converts the SessionData back to session values.
+func (data *SessionData) values(values map[interface{}]interface{}) {
+	if data.ID != "" {
+		values[recordField] = data.Record
+		values[idField] = data.ID
+	}
+	if !data.Created.IsZero() {
+		values[createdField] = data.Created
+	}
+	if !data.Validated.IsZero() {
+		values[validatedField] = data.Validated
+	}
+	if !data.Active.IsZero() {
+		values[activeField] = data.Active
+	}
+	if data.User != "" {
+		values[userField] = data.User
+		values[epochField] = data.Epoch
+	}
+	if data.Return != "" {
+		values[returnField] = data.Return
+	}
+	if data.Stash != nil {
+		values[stashField] = *data.Stash
+	}
+	for key, value := range data.Values {
+		values[key] = value
+	}
+}
+
+/*
+sessionSerializer has the configured Serializer encode the session values as
+SessionData, and restores the type of the authentication data on decoding.
+Other values, like the session ID of ServerStore, are passed as is.
+*/
+type sessionSerializer struct {
+	sz         securecookie.Serializer
+	recordType reflect.Type
+}
+
+func (s *sessionSerializer) Serialize(src interface{}) ([]byte, error) {
+	if values, ok := src.(map[interface{}]interface{}); ok {
+		data, err := sessionData(values)
+		if err != nil {
+			return nil, err
+		}
+		src = data
+	}
+	return s.sz.Serialize(src)
+}
+
+func (s *sessionSerializer) Deserialize(src []byte, dst interface{}) (err error) {
+	switch dst := dst.(type) {
+	case *map[interface{}]interface{}:
+		data := &SessionData{Record: s.newRecord()}
+		if err = s.sz.Deserialize(src, data); err != nil {
+			return
+		}
+		if data.Record, err = s.record(data.Record); err != nil {
+			return
+		}
+		if *dst == nil {
+			*dst = make(map[interface{}]interface{})
+		}
+		data.values(*dst)
+	case *rememberedRecord:
+		dst.Record = s.newRecord()
+		if err = s.sz.Deserialize(src, dst); err != nil {
+			return
+		}
+		dst.Record, err = s.record(dst.Record)
+	default:
+		err = s.sz.Deserialize(src, dst)
+	}
+	return
+}
+
+// newRecord returns a pointer to a new authentication record, for serializers
+// that decode into the existing value, like JSON.
+func (s *sessionSerializer) newRecord() interface{} {
+	if s.recordType == nil {
+		return nil
+	}
+	return reflect.New(s.recordType).Interface()
+}
+
+// record returns the decoded authentication record as a value of the record
+// type. A record that was decoded into a generic type, e.g. a map, is encoded
+// and decoded again into the record type.
+func (s *sessionSerializer) record(decoded interface{}) (record interface{}, err error) {
+	t := s.recordType
+	switch {
+	case t == nil:
+		record = decoded
+	case decoded == nil:
+		record = reflect.Zero(t).Interface()
+	case reflect.TypeOf(decoded) == t:
+		record = decoded
+	case reflect.TypeOf(decoded) == reflect.PointerTo(t):
+		record = reflect.ValueOf(decoded).Elem().Interface()
+	default:
+		var b []byte
+		if b, err = s.sz.Serialize(decoded); err != nil {
+			return
+		}
+		ptr := reflect.New(t)
+		if err = s.sz.Deserialize(b, ptr.Interface()); err == nil {
+			record = ptr.Elem().Interface()
+		}
+	}
+	return
+}
+
+// compressSerializer deflates the serialized data, before it gets encrypted.
+type compressSerializer struct {
+	sz securecookie.Serializer
+}
+
+func (s compressSerializer) Serialize(src interface{}) ([]byte, error) {
+	b, err := s.sz.Serialize(src)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s compressSerializer) Deserialize(src []byte, dst interface{}) error {
+	b, err := io.ReadAll(flate.NewReader(bytes.NewReader(src)))
+	if err != nil {
+		return err
+	}
+	return s.sz.Deserialize(b, dst)
+}
@@ -0,0 +1,115 @@
+package secure
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/securecookie"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeDocumented decodes a value as documented in the README, for other
+// languages.
+func decodeDocumented(t *testing.T, name, value string, keys *Keys, compressed bool) []byte {
+	t.Helper()
+	b, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := bytes.SplitN(b, []byte("|"), 3)
+	if len(parts) != 3 {
+		t.Fatalf("got %d parts; want date|payload|mac", len(parts))
+	}
+	mac := hmac.New(sha256.New, keys.KeyPairs[0])
+	mac.Write([]byte(name + "|" + string(parts[0]) + "|" + string(parts[1])))
+	if !hmac.Equal(mac.Sum(nil), parts[2]) {
+		t.Fatal("MAC mismatch")
+	}
+	payload, err := base64.URLEncoding.DecodeString(string(parts[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(keys.KeyPairs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, len(payload)-aes.BlockSize)
+	cipher.NewCTR(block, payload[:aes.BlockSize]).XORKeyStream(plain, payload[aes.BlockSize:])
+	if compressed {
+		if plain, err = io.ReadAll(flate.NewReader(bytes.NewReader(plain))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return plain
+}
+
+func TestJSONSessionCookie(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		m := newTestManager(t, func(config *Config) {
+			config.Session.Serializer = securecookie.JSONEncoder{}
+			config.Session.Compress = compress
+		})
+		c := newClient()
+		c.logIn(t, m, testRecord{"alice"})
+		if record, ok := c.authentication(m); !ok || record != (testRecord{"alice"}) {
+			t.Fatalf("got %#v, %v; want the record with its type", record, ok)
+		}
+		name := m.session().cookieName()
+		plain := decodeDocumented(t, name, c.cookies[name].Value, m.session().Keys, compress)
+		var data struct {
+			Record  testRecord `json:"record"`
+			ID      string     `json:"id"`
+			Created time.Time  `json:"created"`
+		}
+		if err := json.Unmarshal(plain, &data); err != nil {
+			t.Fatalf("%v: %s", err, plain)
+		}
+		if data.Record.Name != "alice" || data.ID == "" || data.Created.IsZero() {
+			t.Errorf("got %s", plain)
+		}
+	}
+}
+
+func TestJSONFormToken(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Token.Serializer = securecookie.JSONEncoder{}
+	})
+	c := newClient()
+	token := c.formToken(m, "/form", "192.0.2.1:1234")
+	var data FormToken
+	if err := json.Unmarshal(decodeDocumented(t, formTokenName, token, m.token().Keys, false), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.IP != "192.0.2.1" || data.Path != "/form" {
+		t.Errorf("got %+v", data)
+	}
+	if code := c.postToken(m, "/form", token, "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("got %d; want 200", code)
+	}
+}
+
+func TestJSONServerStoreAndRemember(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Serializer = securecookie.JSONEncoder{}
+		config.Session.Store = ServerStore(NewMemoryBackend())
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"}, Persistent())
+	name := m.session().cookieName()
+	if id := decodeDocumented(t, name, c.cookies[name].Value, m.session().Keys, false); !strings.HasPrefix(string(id), `"`) {
+		t.Errorf("got %s; want the session ID as a JSON string", id)
+	}
+	c.closeBrowser()
+	if record, ok := c.authentication(m); !ok || record != (testRecord{"alice"}) {
+		t.Errorf("got %#v, %v; want the remembered record with its type", record, ok)
+	}
+}
@@ -1,20 +1,34 @@
 package secure
 
 import (
+	"encoding/base64"
+	"errors"
 	"github.com/gorilla/context"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"net/http"
+	"reflect"
 	"time"
 )
 
 const (
-	sessionCookie  = "session"
 	recordField    = "ddf77ee1-6a23-4980-8edc-ff4139e98f22"
 	createdField   = "45595a0b-7756-428e-bae0-5f7ded324e92"
 	validatedField = "fe6f1315-9aa1-4083-89a0-dcb6c198654b"
 	returnField    = "eb8cacdd-d65f-441e-a63d-e4da69c2badc"
+	idField        = "0b2e9a3c-5f0e-4d7a-9c61-3e8d2f4b7a15"
+	userField      = "7c4d1e8f-2a6b-4f3e-b5d9-81c0a7e6f243"
+	epochField     = "d3a8f5b2-9e1c-4b7d-a604-5f2e8c9b1d37"
+	activeField    = "5e7b3c91-0d4f-4a8e-9f26-c81b7d5a3e04"
 )
 
+const idLen = 16
+
+// newID returns a random identifier.
+func newID() string {
+	return base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(idLen))
+}
+
 /*
 A Session value is stored in the Config to manage session cryptography.
 */
@@ -32,145 +46,565 @@ type Session struct {
 	// Default value is "/".
 	LogOutPath string
 
+	// PostLogInPath is where LogIn() redirects to if there's no (safe) URL to
+	// return to.
+	// Default value is LogOutPath.
+	PostLogInPath string
+
+	// ReturnHosts are the other hosts that LogIn() may redirect back to, with
+	// https, e.g. "app.example.com".
+	// Default value is nil; only the request's host is allowed.
+	ReturnHosts []string
+
+	// AbsoluteTimeOut is the maximum lifetime of a session, since LogIn(),
+	// regardless of activity. It's checked against the creation time in the
+	// session data; the cookie itself has no Max-Age, and ends with the
+	// browser session.
+	// Default value is 30 days.
+	AbsoluteTimeOut time.Duration
+
+	// IdleTimeOut ends a session that had no authenticated requests for this
+	// long. The last activity time is saved in the cookie at most every tenth
+	// of IdleTimeOut, to limit cookie writes.
+	// Default value is 0; sessions don't time out on inactivity.
+	IdleTimeOut time.Duration
+
+	// RememberTimeOut is how long a Persistent() log in lasts. The
+	// authentication data of the remember-me token series is encrypted with
+	// the session keys, so it must be used within two key rotations (TimeOut)
+	// to stay valid; each use encrypts it with the current keys again.
+	// Default value is 30 days.
+	RememberTimeOut time.Duration
+
+	// Remember keeps the remember-me token series for Persistent() log ins.
+	// Default value is an in-memory store; see NewMemoryRememberStore().
+	// Remember is not synced with the DB.
+	Remember RememberStore `json:"-"`
+
 	// ValidateTimeOut determines whether it's time to have the
 	// cookie data checked by the ValidateCookie function.
 	// Default value is 5 minutes.
 	ValidateTimeOut time.Duration
 
-	store *sessions.CookieStore
+	// Store creates the store that manages the session cookie; see
+	// CookieStore(), FilesystemStore(), and ServerStore().
+	// Default value is CookieStore().
+	// Store is not synced with the DB.
+	Store StoreFunc `json:"-"`
+
+	// UserKey returns the key that identifies the user of the authentication
+	// data, for RevokeAll(). If nil, RevokeAll() has no effect.
+	// UserKey is not synced with the DB.
+	UserKey func(record interface{}) string `json:"-"`
+
+	// Epochs keeps the users' epochs for RevokeAll().
+	// Default value is an in-memory store; see NewMemoryEpochSource().
+	// Epochs is not synced with the DB.
+	Epochs EpochSource `json:"-"`
+
+	// Migrate reports whether a value of the anonymous session, e.g. a
+	// shopping cart, is carried over to the new session that LogIn() starts.
+	// Default value is nil; all values are dropped.
+	// Migrate is not synced with the DB.
+	Migrate func(key interface{}) bool `json:"-"`
+
+	// Resume has Handle() save a rejected form submission (a url-encoded POST)
+	// in the session, so that LogIn() can submit it again; see
+	// ResubmitTemplate. Only submissions that passed the CSRF check (of a
+	// SecureRouter, or VerifyFormToken or VerifyDoubleSubmit), before
+	// Handle(), are saved, so that a forged request can't be resubmitted.
+	// Default value is false.
+	Resume bool
+
+	// ResumeMaxSize is the maximum size of a form that Resume saves, in bytes.
+	// Larger forms are not resumed. Mind that the form is stored in the
+	// cookie, unless Store is ServerStore or FilesystemStore.
+	// Default value is 4096.
+	ResumeMaxSize int
+
+	// Cookie sets the attributes of the session cookie.
+	Cookie Cookie
+
+	// Denylist holds the IDs of the sessions that LogOut() ended, so that a
+	// copy of the cookie can't be used any more.
+	// Default value is an in-memory store; see NewMemoryDenylist().
+	// Denylist is not synced with the DB.
+	Denylist Denylist `json:"-"`
+
+	// Serializer encodes the session data in the cookie, e.g.
+	// securecookie.JSONEncoder{}, so that services in other languages can
+	// read it. It gets the data as a SessionData. Changing the Serializer
+	// invalidates the existing cookies.
+	// Default value is nil, for encoding/gob.
+	// Serializer is not synced with the DB.
+	Serializer securecookie.Serializer `json:"-"`
+
+	// Compress compresses the session data before encrypting it, so that
+	// larger authentication data fits in the cookie. Changing Compress
+	// invalidates the existing cookies.
+	// Default value is false.
+	Compress bool
+
+	store          sessions.Store
+	storeKeys      *Keys
+	rememberCodecs []securecookie.Codec
+	recordType     reflect.Type
 }
 
-func (s *Session) getCookie(r *http.Request) (session *sessions.Session) {
-	s.freshen()
-	if s.store == nil {
-		s.store = sessions.NewCookieStore(s.KeyPairs...)
-		s.store.Options = &sessions.Options{
-			MaxAge: int(s.TimeOut / time.Second),
-			Secure: true,
-			Path:   "/",
+// init (re)creates the session store whenever the key set changed, e.g. after
+// key rotation. The store encodes with the current key pair, and decodes with
+// any of the key pairs, so cookies from before the last rotation stay valid.
+func (s *Session) init() {
+	if s.store == nil || s.storeKeys != s.Keys {
+		s.storeKeys = s.Keys
+		storeFunc := s.Store
+		if storeFunc == nil {
+			storeFunc = CookieStore()
+		}
+		serializer := s.serializer()
+		codecs := securecookie.CodecsFromPairs(s.KeyPairs...)
+		for _, codec := range codecs {
+			if c, ok := codec.(*securecookie.SecureCookie); ok {
+				c.MaxAge(int(s.absoluteTimeOut() / time.Second)).SetSerializer(serializer)
+			}
+		}
+		s.store = storeFunc(codecs)
+		s.rememberCodecs = securecookie.CodecsFromPairs(s.KeyPairs...)
+		for _, codec := range s.rememberCodecs {
+			if c, ok := codec.(*securecookie.SecureCookie); ok {
+				c.MaxAge(int(s.rememberTimeOut() / time.Second)).MaxLength(0).SetSerializer(serializer)
+			}
 		}
 	}
-	session, _ = s.store.Get(r, sessionCookie)
+}
+
+func (s *Session) serializer() (serializer securecookie.Serializer) {
+	serializer = securecookie.GobEncoder{}
+	if s.Serializer != nil {
+		serializer = &sessionSerializer{s.Serializer, s.recordType}
+	}
+	if s.Compress {
+		serializer = compressSerializer{serializer}
+	}
 	return
 }
 
-func create(w http.ResponseWriter, r *http.Request, record interface{}, redirect bool) (err error) {
-	session := sessionKeys.getCookie(r)
+const defaultAbsoluteTimeOut = 30 * 24 * time.Hour
+
+func (s *Session) absoluteTimeOut() time.Duration {
+	if s.AbsoluteTimeOut > 0 {
+		return s.AbsoluteTimeOut
+	}
+	return defaultAbsoluteTimeOut
+}
+
+// sessionKey is the context key for the Manager's session of the request. The
+// sessions.Registry would share the session between Managers that have the
+// same cookie name.
+type sessionKey struct {
+	m *Manager
+}
+
+// getCookie returns the session that the request's cookie holds, or else a new
+// one. It's loaded once per request.
+func (m *Manager) getCookie(r *http.Request) (session *sessions.Session) {
+	if loaded, ok := context.GetOk(r, sessionKey{m}); ok {
+		return loaded.(*sessions.Session)
+	}
+	config := m.session()
+	name := config.cookieName()
+	session, err := config.store.New(r, name)
+	if session == nil {
+		m.logger().Error("secure: loading session failed", "error", err)
+		session = sessions.NewSession(config.store, name)
+		session.IsNew = true
+	}
+	session.Options = config.options()
+	context.Set(r, sessionKey{m}, session)
+	return
+}
+
+// regenerate replaces the session's state with a new session, with a new
+// ID, so that a session that was planted, or copied, before can't be elevated.
+// The old ID is denied. Only the values that 'keep' reports true for are
+// carried over.
+func (m *Manager) regenerate(session *sessions.Session, keep func(key interface{}) bool) {
+	if err := m.deny(session); err != nil {
+		m.logger().Error("secure: denying session failed", "error", err)
+	}
+	if regenerator, ok := session.Store().(interface {
+		regenerate(session *sessions.Session) error
+	}); ok {
+		if err := regenerator.regenerate(session); err != nil {
+			m.logger().Error("secure: deleting old session failed", "error", err)
+		}
+	}
+	values := session.Values
+	session.Values = make(map[interface{}]interface{})
+	for key, value := range values {
+		if keep(key) {
+			session.Values[key] = value
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+}
+
+// migrate reports whether the value of the anonymous session is carried over
+// to the new session on LogIn().
+func (m *Manager) migrate(key interface{}) bool {
+	switch key {
+	case recordField, createdField, validatedField, returnField, idField, userField, epochField, activeField, stashField:
+		return false
+	}
+	if migrate := m.session().Migrate; migrate != nil {
+		return migrate(key)
+	}
+	return false
+}
+
+func (m *Manager) create(w http.ResponseWriter, r *http.Request, record interface{}, login bool) (session *sessions.Session, err error) {
+	if r.TLS == nil {
+		return nil, ErrNoTLS
+	}
+	session = m.getCookie(r)
+	if login {
+		m.regenerate(session, m.migrate)
+	}
 	if session.Values[createdField] == nil {
 		session.Values[createdField] = time.Now()
 	}
+	if session.Values[idField] == nil {
+		// Each log in gets a new ID, for the Denylist
+		session.Values[idField] = newID()
+	}
 	session.Values[recordField] = record
 	session.Values[validatedField] = time.Now()
-	if r.TLS == nil {
-		err = ErrNoTLS
-	} else if e := session.Save(r, w); e != nil {
-		err = ErrTokenNotSaved
-	} else if redirect {
-		path := session.Values[returnField]
-		if path == nil {
-			path = sessionKeys.LogOutPath
+	session.Values[activeField] = time.Now()
+	if login || session.Values[userField] != m.userKey(record) {
+		if err = m.setEpoch(session, record); err != nil {
+			return
+		}
+	}
+	if e := m.save(r, w, session); e != nil {
+		var sizeErr *CookieSizeError
+		if errors.As(e, &sizeErr) {
+			err = sizeErr
+		} else {
+			err = ErrTokenNotSaved
 		}
-		http.Redirect(w, r, path.(string), http.StatusSeeOther)
 	}
 	return
 }
 
-// LogIn creates the cookie and sets the cookie. It redirects back to the path
-// where Authenticate() was called.
+// save saves the session as a browser session cookie, i.e. without Max-Age
+// and Expires, with the Cookie attributes. The store still gets the session's
+// lifetime in the Options, e.g. for expiring the server side data.
+func (m *Manager) save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if err := session.Save(r, w); err != nil {
+		return err
+	}
+	config := m.session()
+	cookies := w.Header()["Set-Cookie"]
+	for i, line := range cookies {
+		if cookie, err := http.ParseSetCookie(line); err == nil && isSessionCookie(cookie.Name, session.Name()) {
+			config.attributes(cookie)
+			cookies[i] = cookie.String()
+		}
+	}
+	return nil
+}
+
+// LogIn creates the cookie and sets the cookie. It redirects back to the URL
+// where Authenticate() was called, or to the ReturnToName form value, if it's
+// on this server (or on one of Session.ReturnHosts), or else to
+// Session.PostLogInPath.
+//
+// If Session.Resume is set, and Handle() rejected a form submission, LogIn()
+// instead writes a page that submits the form again, with a fresh FormToken,
+// or DoubleSubmitToken() for a form that passed VerifyDoubleSubmit.
+//
+// LogIn always starts a new session, with a new ID; values of the session
+// that the client presented are dropped, unless Session.Migrate keeps them.
 //
 // 'record' is the authentication data to store in the cookie, as returned by
 // Authentication()
-func LogIn(w http.ResponseWriter, r *http.Request, record interface{}) (err error) {
-	return create(w, r, record, true)
+//
+// The session ends with the browser session, unless the Persistent() option
+// is given.
+func (m *Manager) LogIn(w http.ResponseWriter, r *http.Request, record interface{}, opts ...LogInOption) (err error) {
+	var options logInOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	// Check before the old session is destroyed
+	if r.TLS == nil {
+		return ErrNoTLS
+	}
+	// End any earlier remember-me series
+	m.forget(w, r)
+	current := m.getCookie(r)
+	target, stash := m.returnTo(r, current), m.stashed(current)
+	session, err := m.create(w, r, record, true)
+	if err != nil {
+		return
+	}
+	if options.persistent {
+		if e := m.remember(w, session); e != nil {
+			m.logger().Error("secure: saving remember-me series failed", "error", e)
+			return ErrTokenNotSaved
+		}
+	}
+	m.event(Event{Type: EventLogIn, Request: r, Record: record})
+	if stash != nil && stash.URL == target && m.resubmit(w, r, stash) {
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+	return
+}
+
+// LogIn calls LogIn on the default Manager.
+func LogIn(w http.ResponseWriter, r *http.Request, record interface{}, opts ...LogInOption) (err error) {
+	return manager.LogIn(w, r, record, opts...)
+}
+
+// An UpdateOption modifies how Update() changes the session.
+type UpdateOption func(*updateOptions)
+
+type updateOptions struct {
+	regenerate bool
+}
+
+/*
+Regenerate has Update() issue a new session ID, and deny the old one, e.g.
+after a change of the user's privileges. The session's values, and its
+AbsoluteTimeOut, are kept.
+*/
+func Regenerate() UpdateOption {
+	return func(o *updateOptions) {
+		o.regenerate = true
+	}
 }
 
 // Update updates the authentication data in the cookie.
-func Update(w http.ResponseWriter, r *http.Request, record interface{}) (err error) {
-	return create(w, r, record, false)
+func (m *Manager) Update(w http.ResponseWriter, r *http.Request, record interface{}, opts ...UpdateOption) (err error) {
+	var options updateOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if r.TLS == nil {
+		return ErrNoTLS
+	}
+	if options.regenerate {
+		m.regenerate(m.getCookie(r), func(key interface{}) bool {
+			return key != idField
+		})
+	}
+	_, err = m.create(w, r, record, false)
+	return
 }
 
-func sessionCurrent(session *sessions.Session) (current bool) {
-	if created := session.Values[createdField]; created == nil {
+// Update calls Update on the default Manager.
+func Update(w http.ResponseWriter, r *http.Request, record interface{}, opts ...UpdateOption) (err error) {
+	return manager.Update(w, r, record, opts...)
+}
+
+func (m *Manager) sessionCurrent(session *sessions.Session) (current bool) {
+	config := m.session()
+	if created, ok := session.Values[createdField].(time.Time); !ok {
+	} else if time.Since(created) >= config.absoluteTimeOut() {
+	} else if config.IdleTimeOut <= 0 {
+		current = true
 	} else {
-		current = time.Since(created.(time.Time)) < sessionKeys.TimeOut
+		active, ok := session.Values[activeField].(time.Time)
+		if !ok {
+			active = created
+		}
+		current = time.Since(active) < config.IdleTimeOut
 	}
 	return
 }
 
-func accountCurrent(session *sessions.Session, w http.ResponseWriter, r *http.Request) (current bool) {
+// touch refreshes the session's last activity time, if it's due. It reports
+// whether the session changed.
+func (m *Manager) touch(session *sessions.Session) (changed bool) {
+	idleTimeOut := m.session().IdleTimeOut
+	if active, ok := session.Values[activeField].(time.Time); idleTimeOut <= 0 || (ok && time.Since(active) < idleTimeOut/10) {
+		return
+	}
+	session.Values[activeField] = time.Now()
+	return true
+}
+
+func (m *Manager) accountCurrent(session *sessions.Session, r *http.Request) (current, changed bool) {
 	if validated := session.Values[validatedField]; validated == nil {
-	} else if cur := time.Since(validated.(time.Time)) < sessionKeys.ValidateTimeOut; cur {
+	} else if cur := time.Since(validated.(time.Time)) < m.session().ValidateTimeOut; cur {
 		current = true
-	} else if record, cur := validate(session.Values[recordField]); cur {
+	} else if record, cur := m.validate(session.Values[recordField]); cur {
 		session.Values[recordField] = record
 		session.Values[validatedField] = time.Now()
-		_ = session.Save(r, w)
-		current = true
+		m.event(Event{Type: EventValidation, Request: r, Record: record})
+		current, changed = true, true
 	}
 	return
 }
 
-type contextKey int
-
-const authKey contextKey = 0
+// authKey is the context key for the authentication record; the Manager
+// itself is part of the key, so that several Managers can authenticate the
+// same request.
+type authKey struct {
+	m *Manager
+}
 
-func authenticate(w http.ResponseWriter, r *http.Request, optional ...bool) (authenticated bool) {
+func (m *Manager) authenticate(w http.ResponseWriter, r *http.Request, optional ...bool) (authenticated bool) {
 	enforce := true
 	if len(optional) > 0 {
 		enforce = !optional[0]
 	}
-	session := sessionKeys.getCookie(r)
-	if !session.IsNew && sessionCurrent(session) && accountCurrent(session, w, r) {
-		context.Set(r, authKey, session.Values[recordField])
+	session := m.getCookie(r)
+	var reason string
+	if session.IsNew {
+		if _, err := r.Cookie(m.session().cookieName()); err == nil {
+			reason = "invalid"
+		}
+	} else if _, ok := session.Values[recordField]; !ok {
+	} else if !m.sessionCurrent(session) {
+		reason = "expired"
+	} else if !m.epochCurrent(session) {
+		reason = "revoked"
+	} else if !m.allowed(session) {
+		reason = "denied"
+	} else if current, changed := m.accountCurrent(session, r); !current {
+		reason = "invalidated"
+	} else {
+		if m.touch(session) || changed {
+			_ = m.save(r, w, session)
+		}
+		context.Set(r, authKey{m}, session.Values[recordField])
 		authenticated = true
-	} else if enforce {
-		session = clearCookie(r)
-		session.Values[returnField] = r.URL.Path
-		_ = session.Save(r, w)
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte(`<!DOCTYPE html>
-			<html>
-				<head>
-					<meta charset="utf-8">
-					<meta http-equiv="refresh" content="0; url=` + sessionKeys.LogInPath + `">
-				</head>
-				<body>
-					<h2>Forbidden</h2>
-					<a id="location" href="` + sessionKeys.LogInPath + `">Log in</a>
-				</body>
-			</html>
-		`))
+	}
+	if reason != "" {
+		m.event(Event{Type: EventAuthenticationFailure, Request: r, Reason: reason})
+	}
+	if !authenticated {
+		if session := m.recall(w, r); session != nil {
+			context.Set(r, authKey{m}, session.Values[recordField])
+			authenticated = true
+		}
+	}
+	if !authenticated && enforce {
+		session = m.clearCookie(r)
+		session.Values[returnField] = r.URL.RequestURI()
+		m.stash(w, r, session)
+		_ = m.save(r, w, session)
+		config := m.current()
+		config.unauthenticated()(w, r, config.public(&Problem{
+			Title:    "Unauthorized",
+			Status:   http.StatusUnauthorized,
+			Detail:   "Log in to access this resource.",
+			Instance: r.URL.Path,
+			Reason:   reason,
+			Location: config.Session.LogInPath,
+		}))
 	}
 	return
 }
 
+// authValue holds the authentication record in the request's context.
+type authValue struct {
+	record interface{}
+}
+
+// authentication returns the authentication record, from the request's
+// context, as set by the middleware, or else from the gorilla context.
+func (m *Manager) authentication(r *http.Request) (record interface{}, ok bool) {
+	if value, found := r.Context().Value(authKey{m}).(authValue); found {
+		return value.record, true
+	}
+	return context.GetOk(r, authKey{m})
+}
+
 /*
 Authentication returns the record that was stored in the cookie on LogIn().
 
-Call from a Handle wrapped in secure.Handle or secure.IfHandle.
+Call from a Handle wrapped in Handle or IfHandle, or from a handler wrapped in
+RequireAuthentication or OptionalAuthentication.
+*/
+func (m *Manager) Authentication(r *http.Request) interface{} {
+	record, _ := m.authentication(r)
+	return record
+}
+
+/*
+Authentication calls Authentication on the default Manager.
+
+Call from a Handle wrapped in Handle or IfHandle, or from a handler wrapped in
+RequireAuthentication or OptionalAuthentication.
 */
 func Authentication(r *http.Request) interface{} {
-	return context.Get(r, authKey)
+	return manager.Authentication(r)
+}
+
+/*
+Authenticated reports whether the client is logged in.
+
+Call from a Handle wrapped in Handle or IfHandle, or from a handler wrapped in
+RequireAuthentication or OptionalAuthentication.
+*/
+func (m *Manager) Authenticated(r *http.Request) bool {
+	_, ok := m.authentication(r)
+	return ok
+}
+
+/*
+Authenticated calls Authenticated on the default Manager.
+*/
+func Authenticated(r *http.Request) bool {
+	return manager.Authenticated(r)
 }
 
-func clearCookie(r *http.Request) (session *sessions.Session) {
-	session = sessionKeys.getCookie(r)
+// sessionID returns the ID of the client's session, or "" if the client isn't
+// logged in.
+func (m *Manager) sessionID(r *http.Request) (id string) {
+	session := m.getCookie(r)
+	if _, ok := session.Values[recordField]; ok {
+		id, _ = session.Values[idField].(string)
+	}
+	return
+}
+
+func (m *Manager) clearCookie(r *http.Request) (session *sessions.Session) {
+	session = m.getCookie(r)
 	delete(session.Values, recordField)
 	delete(session.Values, createdField)
 	delete(session.Values, validatedField)
+	delete(session.Values, idField)
+	delete(session.Values, userField)
+	delete(session.Values, epochField)
+	delete(session.Values, activeField)
+	delete(session.Values, stashField)
 	return
 }
 
-// LogOut deletes the cookie. If 'redirect' is 'true', the request is redirected
-// to config.LogOutPath.
-func LogOut(w http.ResponseWriter, r *http.Request, redirect bool) {
-	session := clearCookie(r)
-	session.Options = &sessions.Options{
-		MaxAge: -1,
+// LogOut deletes the cookie, and adds the session's ID to the Denylist. If
+// 'redirect' is 'true', the request is redirected to config.LogOutPath.
+func (m *Manager) LogOut(w http.ResponseWriter, r *http.Request, redirect bool) {
+	current := m.getCookie(r)
+	record := current.Values[recordField]
+	if err := m.deny(current); err != nil {
+		m.logger().Error("secure: denying session failed", "error", err)
 	}
-	_ = session.Save(r, w)
+	m.forget(w, r)
+	session := m.clearCookie(r)
+	session.Options.MaxAge = -1
+	_ = m.save(r, w, session)
+	m.event(Event{Type: EventLogOut, Request: r, Record: record})
 	if redirect {
-		http.Redirect(w, r, sessionKeys.LogOutPath, http.StatusSeeOther)
+		http.Redirect(w, r, m.session().LogOutPath, http.StatusSeeOther)
 	}
 }
+
+// LogOut calls LogOut on the default Manager.
+func LogOut(w http.ResponseWriter, r *http.Request, redirect bool) {
+	manager.LogOut(w, r, redirect)
+}
@@ -0,0 +1,138 @@
+package secure
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAbsoluteTimeOut(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.AbsoluteTimeOut = 100 * time.Millisecond
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	if _, ok := c.authentication(m); !ok {
+		t.Fatal("session rejected")
+	}
+	time.Sleep(110 * time.Millisecond)
+	if _, ok := c.authentication(m); ok {
+		t.Error("session accepted after AbsoluteTimeOut")
+	}
+}
+
+func TestIdleTimeOut(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.IdleTimeOut = 200 * time.Millisecond
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, ok := c.authentication(m); !ok {
+			t.Fatal("active session rejected")
+		}
+	}
+	time.Sleep(210 * time.Millisecond)
+	if _, ok := c.authentication(m); ok {
+		t.Error("session accepted after IdleTimeOut")
+	}
+}
+
+func TestActivityThrottle(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.IdleTimeOut = time.Hour
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	w := c.do(m.RequireAuthentication(okHandler), newRequest("GET", "/", nil))
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("got %d cookies; want no write within a tenth of IdleTimeOut", len(cookies))
+	}
+}
+
+func TestBrowserSessionCookie(t *testing.T) {
+	m := newTestManager(t)
+	w := newClient().logIn(t, m, testRecord{"alice"})
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == m.session().cookieName() && (cookie.MaxAge != 0 || !cookie.Expires.IsZero()) {
+			t.Errorf("got Max-Age %d, Expires %v; want a browser session cookie", cookie.MaxAge, cookie.Expires)
+		}
+	}
+}
+
+func TestLogInRequiresTLS(t *testing.T) {
+	m := newTestManager(t)
+	r := newRequest("POST", "/session", nil)
+	r.TLS = nil
+	var err error
+	newClient().do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err = m.LogIn(w, r, testRecord{"alice"})
+	}), r)
+	if err != ErrNoTLS {
+		t.Errorf("got %v; want ErrNoTLS", err)
+	}
+}
+
+func TestNoTLSKeepsSession(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Store = ServerStore(NewMemoryBackend())
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"}, Persistent())
+	for name, call := range map[string]func(w http.ResponseWriter, r *http.Request) error{
+		"LogIn": func(w http.ResponseWriter, r *http.Request) error {
+			return m.LogIn(w, r, testRecord{"mallory"})
+		},
+		"Update": func(w http.ResponseWriter, r *http.Request) error {
+			return m.Update(w, r, testRecord{"mallory"}, Regenerate())
+		},
+	} {
+		r := newRequest("POST", "/session", nil)
+		r.TLS = nil
+		var err error
+		w := c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err = call(w, r)
+		}), r)
+		if err != ErrNoTLS {
+			t.Errorf("%s: got %v; want ErrNoTLS", name, err)
+		}
+		if cookies := w.Result().Cookies(); len(cookies) != 0 {
+			t.Errorf("%s: got cookies %v; want none", name, cookies)
+		}
+		if record, ok := c.authentication(m); !ok || record != (testRecord{"alice"}) {
+			t.Errorf("%s: got %v, %v; want the session kept", name, record, ok)
+		}
+	}
+	c.closeBrowser()
+	if _, ok := c.authentication(m); !ok {
+		t.Error("remember-me series dropped")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Migrate = func(key interface{}) bool {
+			return key == "cart"
+		}
+	})
+	c := newClient()
+	c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := m.getCookie(r)
+		session.Values["cart"] = "book"
+		session.Values["theme"] = "dark"
+		if err := m.save(r, w, session); err != nil {
+			t.Error(err)
+		}
+	}), newRequest("GET", "/", nil))
+	c.logIn(t, m, testRecord{"alice"})
+	c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		values := m.getCookie(r).Values
+		if values["cart"] != "book" {
+			t.Errorf("got cart %v; want it carried over", values["cart"])
+		}
+		if values["theme"] != nil {
+			t.Errorf("got theme %v; want it dropped", values["theme"])
+		}
+	}), newRequest("GET", "/", nil))
+}
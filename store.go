@@ -0,0 +1,383 @@
+package secure
+
+import (
+	"fmt"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+A StoreFunc creates the sessions.Store that the session cookie is managed with,
+from the codecs for the current session keys. It's called again whenever the
+keys change, e.g. after key rotation.
+*/
+type StoreFunc func(codecs []securecookie.Codec) sessions.Store
+
+/*
+CookieStore stores the complete session data in the cookie. It's the default
+Session.Store.
+
+Session data that's too large for one cookie is split over numbered cookies
+("name.1", "name.2", ...). The opt_maxChunks argument sets the maximum number of
+cookies; the default is 4. Note that web servers and proxies limit the total
+size of the request headers, often to 8KB.
+*/
+func CookieStore(opt_maxChunks ...int) StoreFunc {
+	maxChunks := defaultMaxChunks
+	if len(opt_maxChunks) == 1 && opt_maxChunks[0] > 0 {
+		maxChunks = opt_maxChunks[0]
+	}
+	return func(codecs []securecookie.Codec) sessions.Store {
+		for _, codec := range codecs {
+			if c, ok := codec.(*securecookie.SecureCookie); ok {
+				c.MaxLength(0)
+			}
+		}
+		return &cookieStore{codecs, maxChunks}
+	}
+}
+
+const (
+	defaultMaxChunks = 4
+
+	// chunkSize is the maximum length of a cookie value; browsers limit the
+	// cookie, including its name and attributes, to 4096 bytes.
+	chunkSize = 3800
+)
+
+/*
+A CookieSizeError is returned by LogIn() and Update() if the session data
+doesn't fit in the session cookies. Consider storing less data, setting
+Session.Compress, or using ServerStore.
+*/
+type CookieSizeError struct {
+
+	// Size is the length of the encoded session data.
+	Size int
+
+	// Max is the maximum length that fits in the cookies.
+	Max int
+}
+
+func (e *CookieSizeError) Error() string {
+	return fmt.Sprintf("secure: session data of %d bytes exceeds the cookie maximum of %d bytes", e.Size, e.Max)
+}
+
+// Unwrap has errors.Is(err, ErrTokenNotSaved) report true.
+func (e *CookieSizeError) Unwrap() error {
+	return ErrTokenNotSaved
+}
+
+type cookieStore struct {
+	codecs    []securecookie.Codec
+	maxChunks int
+}
+
+func (s *cookieStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *cookieStore) New(r *http.Request, name string) (session *sessions.Session, err error) {
+	session = sessions.NewSession(s, name)
+	session.Options = &sessions.Options{Path: "/"}
+	session.IsNew = true
+	value, ok := s.read(r, name)
+	if !ok {
+		return
+	}
+	if err = securecookie.DecodeMulti(name, value, &session.Values, s.codecs...); err == nil {
+		session.IsNew = false
+	}
+	return
+}
+
+func (s *cookieStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	var value string
+	if session.Options.MaxAge >= 0 {
+		var err error
+		if value, err = securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...); err != nil {
+			return err
+		}
+	}
+	return s.write(w, r, session.Name(), value, session.Options)
+}
+
+func chunkName(name string, i int) string {
+	return name + "." + strconv.Itoa(i)
+}
+
+// read reassembles the cookie value from its chunks. A chunked value starts
+// with the number of chunks and a dot, which the encoded value can't contain.
+func (s *cookieStore) read(r *http.Request, name string) (value string, ok bool) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return
+	}
+	count, first, chunked := strings.Cut(cookie.Value, ".")
+	if !chunked {
+		return cookie.Value, true
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 2 || n > s.maxChunks {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(first)
+	for i := 1; i < n; i++ {
+		chunk, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			return
+		}
+		b.WriteString(chunk.Value)
+	}
+	return b.String(), true
+}
+
+// write sets the cookie value, split over as many cookies as needed, and
+// deletes the request's chunks that are no longer needed. An empty value
+// deletes the cookie.
+func (s *cookieStore) write(w http.ResponseWriter, r *http.Request, name, value string, options *sessions.Options) error {
+	n := (len(value) + chunkSize - 1) / chunkSize
+	if n > s.maxChunks {
+		return &CookieSizeError{Size: len(value), Max: s.maxChunks * chunkSize}
+	}
+	if n <= 1 {
+		http.SetCookie(w, sessions.NewCookie(name, value, options))
+		n = 1
+	} else {
+		for i := 0; i < n; i++ {
+			chunk := value[i*chunkSize : min((i+1)*chunkSize, len(value))]
+			if i == 0 {
+				http.SetCookie(w, sessions.NewCookie(name, strconv.Itoa(n)+"."+chunk, options))
+			} else {
+				http.SetCookie(w, sessions.NewCookie(chunkName(name, i), chunk, options))
+			}
+		}
+	}
+	deleted := *options
+	deleted.MaxAge = -1
+	for i := n; ; i++ {
+		if _, err := r.Cookie(chunkName(name, i)); err != nil {
+			break
+		}
+		http.SetCookie(w, sessions.NewCookie(chunkName(name, i), "", &deleted))
+	}
+	return nil
+}
+
+/*
+FilesystemStore stores the session data in files in the given directory; the
+cookie only carries the session ID.
+*/
+func FilesystemStore(path string) StoreFunc {
+	if path == "" {
+		path = os.TempDir()
+	}
+	return func(codecs []securecookie.Codec) sessions.Store {
+		store := sessions.NewFilesystemStore(path)
+		store.Codecs = codecs
+		store.MaxLength(0)
+		return &filesystemStore{store, path}
+	}
+}
+
+// filesystemStore adds the regenerate hook to sessions.FilesystemStore.
+type filesystemStore struct {
+	*sessions.FilesystemStore
+	path string
+}
+
+func (s *filesystemStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *filesystemStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	loaded, err := s.FilesystemStore.New(r, name)
+	// Bind the session to the wrapper, for the regenerate hook
+	session := sessions.NewSession(s, name)
+	session.ID = loaded.ID
+	session.Values = loaded.Values
+	session.Options = loaded.Options
+	session.IsNew = loaded.IsNew
+	return session, err
+}
+
+// regenerate deletes the session's file, for a new ID.
+func (s *filesystemStore) regenerate(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(s.path, "session_"+session.ID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+/*
+A Backend stores encrypted session data by session ID, for ServerStore.
+Implement it on the application's database to share sessions between servers.
+*/
+type Backend interface {
+
+	// Load returns the data for the session ID, or nil if there is none.
+	Load(id string) (data []byte, err error)
+
+	// Save stores the data for the session ID, until it expires.
+	Save(id string, data []byte, expires time.Time) error
+
+	// Delete removes the data for the session ID.
+	Delete(id string) error
+}
+
+/*
+ServerStore stores the session data in the given Backend; the cookie only
+carries the session ID, so that sessions can be revoked on the server.
+*/
+func ServerStore(backend Backend) StoreFunc {
+	return func(codecs []securecookie.Codec) sessions.Store {
+		for _, codec := range codecs {
+			if c, ok := codec.(*securecookie.SecureCookie); ok {
+				c.MaxLength(0)
+			}
+		}
+		return &serverStore{backend, codecs}
+	}
+}
+
+// defaultServerAge is how long a Backend keeps a session that has no MaxAge.
+const defaultServerAge = 24 * time.Hour
+
+type serverStore struct {
+	backend Backend
+	codecs  []securecookie.Codec
+}
+
+func (s *serverStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *serverStore) New(r *http.Request, name string) (session *sessions.Session, err error) {
+	session = sessions.NewSession(s, name)
+	session.Options = &sessions.Options{Path: "/"}
+	session.IsNew = true
+	cookie, e := r.Cookie(name)
+	if e != nil {
+		return
+	}
+	if err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.codecs...); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = s.backend.Load(session.ID); err != nil || data == nil {
+		return
+	}
+	if err = securecookie.DecodeMulti(name, string(data), &session.Values, s.codecs...); err == nil {
+		session.IsNew = false
+	}
+	return
+}
+
+func (s *serverStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if session.ID == "" {
+		session.ID = newID()
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return err
+	}
+	age := time.Duration(session.Options.MaxAge) * time.Second
+	if age == 0 {
+		age = defaultServerAge
+	}
+	if err := s.backend.Save(session.ID, []byte(data), time.Now().Add(age)); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// regenerate deletes the session's data from the Backend, for a new ID.
+func (s *serverStore) regenerate(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	return s.backend.Delete(session.ID)
+}
+
+type memoryEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+type memoryBackend struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	purged  time.Time
+}
+
+/*
+NewMemoryBackend returns a Backend that keeps the sessions in memory. Sessions
+are lost on restart, and aren't shared between servers.
+*/
+func NewMemoryBackend() Backend {
+	return &memoryBackend{entries: make(map[string]memoryEntry)}
+}
+
+func (b *memoryBackend) Load(id string) (data []byte, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if entry, ok := b.entries[id]; ok && time.Now().Before(entry.expires) {
+		data = entry.data
+	}
+	return
+}
+
+func (b *memoryBackend) Save(id string, data []byte, expires time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[id] = memoryEntry{data, expires}
+	b.purge()
+	return nil
+}
+
+func (b *memoryBackend) Delete(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.entries, id)
+	return nil
+}
+
+// purge drops the expired entries, at most once a minute.
+func (b *memoryBackend) purge() {
+	now := time.Now()
+	if now.Sub(b.purged) < time.Minute {
+		return
+	}
+	b.purged = now
+	for id, entry := range b.entries {
+		if !now.Before(entry.expires) {
+			delete(b.entries, id)
+		}
+	}
+}
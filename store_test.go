@@ -0,0 +1,201 @@
+package secure
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// update updates the client's authentication record.
+func (c *client) update(t testing.TB, m *Manager, record interface{}, opts ...UpdateOption) {
+	t.Helper()
+	c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Update(w, r, record, opts...); err != nil {
+			t.Error(err)
+		}
+	}), newRequest("POST", "/", nil))
+}
+
+// logOut logs the client out.
+func (c *client) logOut(m *Manager) {
+	c.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.LogOut(w, r, false)
+	}), newRequest("POST", "/session", nil))
+}
+
+// copyCookies returns a client with a copy of the cookies.
+func (c *client) copyCookies() *client {
+	copied := newClient()
+	for name, cookie := range c.cookies {
+		copied.cookies[name] = cookie
+	}
+	return copied
+}
+
+func testStore(t *testing.T, store StoreFunc, serverSide bool) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Store = store
+	})
+	c := newClient()
+	record := testRecord{strings.Repeat("alice", 200)}
+	c.logIn(t, m, record)
+	if got, ok := c.authentication(m); !ok || got != record {
+		t.Fatal("session rejected")
+	}
+	if size := len(c.cookies[m.session().cookieName()].Value); serverSide && size > 200 {
+		t.Errorf("cookie of %d bytes; want just the session ID", size)
+	}
+	c.update(t, m, testRecord{"bob"})
+	if got, _ := c.authentication(m); got != (testRecord{"bob"}) {
+		t.Errorf("got %v after Update; want bob", got)
+	}
+	stolen := c.copyCookies()
+	c.logOut(m)
+	if _, ok := c.authentication(m); ok {
+		t.Error("session accepted after LogOut")
+	}
+	if _, ok := stolen.authentication(m); ok {
+		t.Error("copied cookie accepted after LogOut")
+	}
+}
+
+func TestCookieStore(t *testing.T) {
+	testStore(t, CookieStore(), false)
+}
+
+func TestServerStore(t *testing.T) {
+	testStore(t, ServerStore(NewMemoryBackend()), true)
+}
+
+func TestFilesystemStore(t *testing.T) {
+	testStore(t, FilesystemStore(t.TempDir()), true)
+}
+
+// sessionFiles returns the names of the session files in the directory.
+func sessionFiles(t *testing.T, dir string) (names []string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return
+}
+
+func TestFilesystemStoreRegeneration(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Store = FilesystemStore(dir)
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	first := sessionFiles(t, dir)
+	if len(first) != 1 {
+		t.Fatalf("got files %v after LogIn; want 1", first)
+	}
+	c.logIn(t, m, testRecord{"alice"})
+	second := sessionFiles(t, dir)
+	if len(second) != 1 || second[0] == first[0] {
+		t.Errorf("got files %v after a second LogIn; want only a new one instead of %v", second, first)
+	}
+	c.update(t, m, testRecord{"bob"}, Regenerate())
+	third := sessionFiles(t, dir)
+	if len(third) != 1 || third[0] == second[0] {
+		t.Errorf("got files %v after Update(Regenerate()); want only a new one instead of %v", third, second)
+	}
+	if got, ok := c.authentication(m); !ok || got != (testRecord{"bob"}) {
+		t.Errorf("got %v, %v; want bob", got, ok)
+	}
+}
+
+func TestServerStoreRevocation(t *testing.T) {
+	backend := NewMemoryBackend().(*memoryBackend)
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Store = ServerStore(backend)
+	})
+	c := newClient()
+	c.logIn(t, m, testRecord{"alice"})
+	backend.mu.Lock()
+	for id := range backend.entries {
+		delete(backend.entries, id)
+	}
+	backend.mu.Unlock()
+	if _, ok := c.authentication(m); ok {
+		t.Error("session accepted after deleting it from the Backend")
+	}
+}
+
+// randomString returns an incompressible string of about n bytes.
+func randomString(n int) string {
+	var b strings.Builder
+	for b.Len() < n {
+		b.WriteString(newID())
+	}
+	return b.String()
+}
+
+// chunks returns the number of session cookies the client holds.
+func (c *client) chunks(m *Manager) (n int) {
+	for name := range c.cookies {
+		if isSessionCookie(name, m.session().cookieName()) {
+			n++
+		}
+	}
+	return
+}
+
+func TestCookieChunks(t *testing.T) {
+	m := newTestManager(t)
+	c := newClient()
+	record := testRecord{randomString(5000)}
+	c.logIn(t, m, record)
+	if n := c.chunks(m); n < 2 {
+		t.Errorf("got %d cookies; want the value split", n)
+	}
+	if got, ok := c.authentication(m); !ok || got != record {
+		t.Fatal("chunked session rejected")
+	}
+	c.update(t, m, testRecord{"alice"})
+	if n := c.chunks(m); n != 1 {
+		t.Errorf("got %d cookies; want the chunks deleted", n)
+	}
+	if got, _ := c.authentication(m); got != (testRecord{"alice"}) {
+		t.Errorf("got %v; want alice", got)
+	}
+}
+
+func TestCookieSizeError(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Store = CookieStore(2)
+	})
+	var err error
+	newClient().do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err = m.LogIn(w, r, testRecord{randomString(8000)})
+	}), newRequest("POST", "/session", nil))
+	var sizeErr *CookieSizeError
+	if !errors.As(err, &sizeErr) || sizeErr.Max != 2*chunkSize || sizeErr.Size <= sizeErr.Max {
+		t.Fatalf("got %v; want a CookieSizeError", err)
+	}
+	if !errors.Is(err, ErrTokenNotSaved) {
+		t.Error("CookieSizeError isn't ErrTokenNotSaved")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Session.Compress = true
+	})
+	c := newClient()
+	record := testRecord{strings.Repeat("alice", 2000)}
+	c.logIn(t, m, record)
+	if n := c.chunks(m); n != 1 {
+		t.Errorf("got %d cookies; want 1", n)
+	}
+	if got, ok := c.authentication(m); !ok || got != record {
+		t.Error("compressed session rejected")
+	}
+}
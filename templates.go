@@ -0,0 +1,115 @@
+package secure
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+// The names of the templates for the built-in pages. Each gets the *Problem as
+// its data.
+const (
+	UnauthenticatedTemplate = "unauthenticated"
+	ForbiddenTemplate       = "forbidden"
+	TokenInvalidTemplate    = "token-invalid"
+)
+
+var defaultTemplates = template.Must(template.New("").Parse(`
+{{define "unauthenticated"}}<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+		<meta http-equiv="refresh" content="0; url={{.Location}}">
+	</head>
+	<body>
+		<h2>Forbidden</h2>
+		<a id="location" href="{{.Location}}">Log in</a>
+	</body>
+</html>
+{{end}}
+
+{{define "forbidden"}}<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+	</head>
+	<body>
+		<h2>{{.Title}}</h2>
+	</body>
+</html>
+{{end}}
+
+{{define "token-invalid"}}<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+	</head>
+	<body>
+		<h2>{{.Title}}</h2>
+		{{if and .Expected .Received}}
+		<table>
+			<tr>
+				<th></th>
+				<th>IP</th>
+				<th>Path</th>
+			</tr>
+			<tr>
+				<th>this</th>
+				<td>{{.Expected.IP}}</td>
+				<td>{{.Expected.Path}}</td>
+			</tr>
+			<tr>
+				<th>that</th>
+				<td>{{.Received.IP}}</td>
+				<td>{{.Received.Path}}</td>
+			</tr>
+		</table>
+		{{end}}
+		<a id="location" href="{{.Location}}">Back</a>
+	</body>
+</html>
+{{end}}
+
+{{define "resubmit"}}<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+	</head>
+	<body>
+		<form method="post" action="{{.URL}}">
+			{{range $name, $values := .Values}}{{range $values}}
+			<input type="hidden" name="{{$name}}" value="{{.}}">
+			{{end}}{{end}}
+			<noscript>
+				<button type="submit">Continue</button>
+			</noscript>
+		</form>
+		<script>document.forms[0].submit()</script>
+	</body>
+</html>
+{{end}}
+`))
+
+// template returns the named template from Config.Templates, or else the
+// built-in one.
+func (c *Config) template(name string) *template.Template {
+	if c.Templates != nil {
+		if t := c.Templates.Lookup(name); t != nil {
+			return t
+		}
+	}
+	return defaultTemplates.Lookup(name)
+}
+
+// render writes the named template, as an HTML page.
+func (c *Config) render(w http.ResponseWriter, name string, status int, data interface{}) {
+	var buf bytes.Buffer
+	if err := c.template(name).Execute(&buf, data); err != nil {
+		c.logger().Error("secure: rendering page failed", "template", name, "error", err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
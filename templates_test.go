@@ -0,0 +1,84 @@
+package secure
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// postMismatch posts a token for another path, and returns the response.
+func postMismatch(t *testing.T, m *Manager, accept string) (status int, body string) {
+	t.Helper()
+	c := newClient()
+	token := c.formToken(m, `/"><script>alert(1)</script>`, "192.0.2.1:1234")
+	r := newRequest("POST", "/form", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set(defaultHeaderName, token)
+	r.Header.Set("Referer", `https://example.com/back"><script>alert(2)</script>`)
+	r.Header.Set("Accept", accept)
+	w := c.do(m.VerifyFormToken(okHandler), r)
+	return w.Code, w.Body.String()
+}
+
+func TestTemplateEscaping(t *testing.T) {
+	m := newTestManager(t)
+	status, body := postMismatch(t, m, "text/html")
+	if status != http.StatusBadRequest {
+		t.Fatalf("got %d; want 400", status)
+	}
+	if strings.Contains(body, "<script>") {
+		t.Errorf("got unescaped input in %s", body)
+	}
+	if !strings.Contains(body, "192.0.2.1") || !strings.Contains(body, "&lt;script&gt;alert(1)") {
+		t.Errorf("got %s; want the escaped diagnostic details", body)
+	}
+}
+
+func TestTemplatesOverride(t *testing.T) {
+	templates := template.Must(template.New("").Parse(`{{define "token-invalid"}}<p>Custom: {{.Reason}} {{.Instance}}</p>{{end}}`))
+	m := newTestManager(t, func(config *Config) {
+		config.Templates = templates
+	})
+	if _, body := postMismatch(t, m, "text/html"); body != "<p>Custom: mismatch /form</p>" {
+		t.Errorf("got %s; want the custom template", body)
+	}
+	w := newClient().do(http.HandlerFunc(m.Forbidden), newRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), "<h2>Forbidden</h2>") {
+		t.Errorf("got %s; want the built-in template", w.Body)
+	}
+}
+
+func TestTemplateError(t *testing.T) {
+	logger := new(testLogger)
+	templates := template.Must(template.New("").Parse(`{{define "forbidden"}}{{.Missing}}{{end}}`))
+	m := newTestManager(t, func(config *Config) {
+		config.Logger = logger
+		config.Templates = templates
+	})
+	w := newClient().do(http.HandlerFunc(m.Forbidden), newRequest("GET", "/", nil))
+	if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "<h2>") {
+		t.Errorf("got %d %s; want a plain 403", w.Code, w.Body)
+	}
+	if !logger.contains("rendering page failed") {
+		t.Error("error wasn't logged")
+	}
+}
+
+func TestProduction(t *testing.T) {
+	logger := new(testLogger)
+	m := newTestManager(t, func(config *Config) {
+		config.Logger = logger
+		config.Production = true
+	})
+	_, body := postMismatch(t, m, "text/html")
+	if strings.Contains(body, "192.0.2.1") || strings.Contains(body, "alert(1)") {
+		t.Errorf("got diagnostic details in %s", body)
+	}
+	if _, body := postMismatch(t, m, "application/json"); strings.Contains(body, "reason") {
+		t.Errorf("got the reason in %s", body)
+	}
+	if !logger.contains("Form token invalid") {
+		t.Error("details weren't logged")
+	}
+}
@@ -3,12 +3,29 @@ package secure
 import (
 	"encoding/gob"
 	"github.com/gorilla/securecookie"
-	"log"
+	"net"
 	"net/http"
-	"strings"
 	"time"
 )
 
+// IPBinding determines how strictly a FormToken is bound to the client's IP
+// address.
+type IPBinding int
+
+const (
+
+	// IPExact requires the client's IP address to be the same as when the
+	// FormToken was created.
+	IPExact IPBinding = iota
+
+	// IPPrefix requires the client's IP address to be in the same /24 (IPv4)
+	// or /64 (IPv6) network as when the FormToken was created.
+	IPPrefix
+
+	// IPNone doesn't bind the FormToken to the client's IP address.
+	IPNone
+)
+
 /*
 A Token value is stored in the Config to manage token cryptography.
 */
@@ -17,23 +34,86 @@ type Token struct {
 	// Keys encapsulates the rotating key data & functionality.
 	*Keys
 
+	// TrustedProxies lists the networks (CIDRs, or single IP addresses) of
+	// the proxies in front of the servers. For requests from a trusted proxy,
+	// the client's IP address is taken from the ForwardedHeader.
+	// Default value is empty; forwarding headers are ignored.
+	TrustedProxies []string
+
+	// ForwardedHeader is the header that the TrustedProxies set, or append
+	// to: "Forwarded" (RFC 7239), "X-Forwarded-For", or "X-Real-IP". Only
+	// this header is read; any other forwarding header may come from the
+	// client.
+	// Default value is "X-Forwarded-For".
+	ForwardedHeader string
+
+	// IPBinding determines how strictly a FormToken is bound to the client's
+	// IP address.
+	// Default value is IPExact.
+	IPBinding IPBinding
+
+	// BindSession binds a FormToken to the client's session, so that it's
+	// rejected for any other session, e.g. combined with IPNone. For clients
+	// that aren't logged in, the FormToken then isn't bound to an identity.
+	// Default value is false.
+	BindSession bool
+
+	// MaxAge is how long a FormToken stays valid after its creation. Key
+	// rotation outdates tokens as well, after two rotations.
+	// Default value is 0; the age is then only limited by key rotation.
+	MaxAge time.Duration
+
+	// OneTimeUse rejects a FormToken that has been used before, preventing
+	// double submission and replay.
+	// Default value is false.
+	OneTimeUse bool
+
+	// Nonces records the used FormTokens, if OneTimeUse is set.
+	// Default value is an in-memory store; see NewMemoryNonceStore().
+	// Nonces is not synced with the DB.
+	Nonces NonceStore `json:"-"`
+
+	// HeaderName is the request header that can carry the encrypted token
+	// string, for AJAX requests.
+	// Default value is "X-CSRF-Token".
+	HeaderName string
+
+	// Serializer encodes the FormToken, e.g. securecookie.JSONEncoder{}, so
+	// that services in other languages can read it. Changing the Serializer
+	// invalidates the existing tokens.
+	// Default value is nil, for encoding/gob.
+	// Serializer is not synced with the DB.
+	Serializer securecookie.Serializer `json:"-"`
+
 	_codecs []securecookie.Codec
+	_keys   *Keys
+	proxies []*net.IPNet
 }
 
-func (t *Token) codecs() []securecookie.Codec {
-	if len(t._codecs) == 0 {
+// init (re)creates the codecs whenever the key set changed, e.g. after key
+// rotation. A Token that's shared with the previous Config is left alone.
+func (t *Token) init() (err error) {
+	if len(t._codecs) == 0 || t._keys != t.Keys {
+		t._keys = t.Keys
+		t.proxies, err = parseProxies(t.TrustedProxies)
 		t._codecs = securecookie.CodecsFromPairs(t.KeyPairs...)
+		if t.Serializer != nil {
+			for _, codec := range t._codecs {
+				if c, ok := codec.(*securecookie.SecureCookie); ok {
+					c.SetSerializer(t.Serializer)
+				}
+			}
+		}
 	}
+	return
+}
+
+func (t *Token) codecs() []securecookie.Codec {
 	return t._codecs
 }
 
-func (t *Token) encode(name string, value interface{}) (s string) {
-	t.freshen()
-	var err error
-	if s, err = securecookie.EncodeMulti(name, value, t.codecs()...); err != nil {
-		log.Panicln("ERROR: encoding form token failed", err)
-	}
-	return
+func (t *Token) encode(name string, value interface{}) (string, error) {
+	return securecookie.EncodeMulti(name, value, t.codecs()...)
 }
 
 func (t *Token) decode(name string, value string, dst interface{}) error {
@@ -54,42 +134,115 @@ type FormToken struct {
 
 	// Timestamp is when the token was created.
 	Timestamp time.Time
+
+	// Session is the ID of the client's session, if Token.BindSession is set.
+	Session string
+
+	// Nonce identifies the token, if Token.OneTimeUse is set.
+	Nonce string
+
+	manager *Manager
 }
 
 /*
 NewFormToken initialises a new FormToken.
 The opt_path argument overrides the default r.URL.Path.
 */
-func NewFormToken(r *http.Request, opt_path ...string) *FormToken {
+func (m *Manager) NewFormToken(r *http.Request, opt_path ...string) *FormToken {
 	path := r.URL.Path
 	if len(opt_path) == 1 {
 		path = opt_path[0]
 	}
-	return &FormToken{
-		IP:        ip(r),
+	token := m.token()
+	f := &FormToken{
+		IP:        token.clientIP(r),
 		Timestamp: time.Now(),
 		Path:      path,
+		manager:   m,
+	}
+	if token.BindSession {
+		f.Session = m.sessionID(r)
 	}
+	if token.OneTimeUse {
+		f.Nonce = newID()
+	}
+	return f
+}
+
+/*
+NewFormToken calls NewFormToken on the default Manager.
+*/
+func NewFormToken(r *http.Request, opt_path ...string) *FormToken {
+	return manager.NewFormToken(r, opt_path...)
+}
+
+// keys returns the token keys of the Manager that created the FormToken, or
+// the default Manager's.
+func (f *FormToken) keys() *Token {
+	return f.manager.orDefault().token()
 }
 
 const formTokenName = "4f3a0292-59c5-488a-b3fb-6e503c929331"
 
 /*
-String returns the encrypted token string.
+String returns the encrypted token string, or "" if encoding failed; the error
+is logged.
 */
 func (f *FormToken) String() string {
-	return tokenKeys.encode(formTokenName, f)
+	s, err := f.Encode()
+	if err != nil {
+		f.manager.orDefault().logger().Error("secure: encoding form token failed", "error", err)
+	}
+	return s
+}
+
+/*
+Encode returns the encrypted token string, or the error of encoding it.
+*/
+func (f *FormToken) Encode() (string, error) {
+	return f.keys().encode(formTokenName, f)
 }
 
 /*
 Parse populates the data fields from an encrypted token string.
 */
 func (f *FormToken) Parse(s string) error {
-	return tokenKeys.decode(formTokenName, s, f)
+	return f.keys().decode(formTokenName, s, f)
 }
 
-func ip(r *http.Request) string {
-	return strings.Split(r.RemoteAddr, ":")[0]
+const defaultHeaderName = "X-CSRF-Token"
+
+func (t *Token) headerName() string {
+	if t.HeaderName != "" {
+		return t.HeaderName
+	}
+	return defaultHeaderName
+}
+
+// expired reports whether the FormToken is older than the Token's MaxAge.
+func (f *FormToken) expired(t *Token) bool {
+	return t.MaxAge > 0 && time.Since(f.Timestamp) > t.MaxAge
+}
+
+// useFormToken records the FormToken's nonce as used, if the Token is configured for
+// one time use, and reports whether it wasn't used before.
+func (m *Manager) useFormToken(t *Token, f *FormToken) (fresh bool, err error) {
+	if !t.OneTimeUse {
+		return true, nil
+	}
+	if f.Nonce == "" {
+		return false, nil
+	}
+	// Tokens get outdated after two key rotations at most
+	lifetime := 3 * t.TimeOut
+	if t.MaxAge > 0 && t.MaxAge < lifetime {
+		lifetime = t.MaxAge
+	}
+	nonces := t.Nonces
+	if nonces == nil {
+		nonces = m.nonces
+	}
+	return nonces.Use(f.Nonce, f.Timestamp.Add(lifetime))
 }
 
 func init() {
@@ -0,0 +1,123 @@
+package secure
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// okHandler responds with status 200 OK.
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+// postToken posts the encrypted token string to the path, from the remote
+// address, through VerifyFormToken, and returns the status code.
+func (c *client) postToken(m *Manager, path, token, remoteAddr string) int {
+	r := newRequest("POST", path, nil)
+	r.RemoteAddr = remoteAddr
+	r.Header.Set(defaultHeaderName, token)
+	return c.do(m.VerifyFormToken(okHandler), r).Code
+}
+
+// formToken returns a fresh encrypted token string for the path, as if
+// requested from the remote address.
+func (c *client) formToken(m *Manager, path, remoteAddr string) (token string) {
+	r := newRequest("GET", path, nil)
+	r.RemoteAddr = remoteAddr
+	c.do(m.OptionalAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = m.NewFormToken(r).String()
+	})), r)
+	return
+}
+
+func TestIPBinding(t *testing.T) {
+	tests := []struct {
+		binding  IPBinding
+		from, to string
+		want     bool
+	}{
+		{IPExact, "192.0.2.1", "192.0.2.1", true},
+		{IPExact, "192.0.2.1", "192.0.2.2", false},
+		{IPExact, "2001:db8::1", "2001:db8::1", true},
+		{IPPrefix, "192.0.2.1", "192.0.2.200", true},
+		{IPPrefix, "192.0.2.1", "192.0.3.1", false},
+		{IPPrefix, "2001:db8:0:1::1", "2001:db8:0:1:ffff::2", true},
+		{IPPrefix, "2001:db8:0:1::1", "2001:db8:0:2::1", false},
+		{IPPrefix, "192.0.2.1", "2001:db8::1", false},
+		{IPNone, "192.0.2.1", "2001:db8::1", true},
+	}
+	for _, test := range tests {
+		token := &Token{IPBinding: test.binding}
+		if got := token.ipMatch(test.from, test.to); got != test.want {
+			t.Errorf("%v: ipMatch(%s, %s) = %v; want %v", test.binding, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestFormTokenIP(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Token.IPBinding = IPPrefix
+	})
+	c := newClient()
+	token := c.formToken(m, "/form", "192.0.2.1:1234")
+	if code := c.postToken(m, "/form", token, "192.0.2.99:1234"); code != http.StatusOK {
+		t.Errorf("same network: got %d; want 200", code)
+	}
+	if code := c.postToken(m, "/form", token, "198.51.100.1:1234"); code != http.StatusBadRequest {
+		t.Errorf("other network: got %d; want 400", code)
+	}
+	if code := c.postToken(m, "/other", token, "192.0.2.1:1234"); code != http.StatusBadRequest {
+		t.Errorf("other path: got %d; want 400", code)
+	}
+}
+
+func TestFormTokenBindSession(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Token.IPBinding = IPNone
+		config.Token.BindSession = true
+	})
+	alice, mallory := newClient(), newClient()
+	alice.logIn(t, m, testRecord{"alice"})
+	mallory.logIn(t, m, testRecord{"mallory"})
+	token := alice.formToken(m, "/form", "192.0.2.1:1234")
+	if code := alice.postToken(m, "/form", token, "198.51.100.1:1234"); code != http.StatusOK {
+		t.Errorf("same session: got %d; want 200", code)
+	}
+	if code := mallory.postToken(m, "/form", token, "192.0.2.1:1234"); code != http.StatusBadRequest {
+		t.Errorf("other session: got %d; want 400", code)
+	}
+	if code := newClient().postToken(m, "/form", token, "192.0.2.1:1234"); code != http.StatusBadRequest {
+		t.Errorf("no session: got %d; want 400", code)
+	}
+}
+
+func TestFormTokenMaxAge(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Token.MaxAge = 50 * time.Millisecond
+	})
+	c := newClient()
+	token := c.formToken(m, "/form", "192.0.2.1:1234")
+	if code := c.postToken(m, "/form", token, "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("fresh: got %d; want 200", code)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if code := c.postToken(m, "/form", token, "192.0.2.1:1234"); code != http.StatusBadRequest {
+		t.Errorf("expired: got %d; want 400", code)
+	}
+}
+
+func TestFormTokenOneTimeUse(t *testing.T) {
+	m := newTestManager(t, func(config *Config) {
+		config.Token.OneTimeUse = true
+	})
+	c := newClient()
+	token := c.formToken(m, "/form", "192.0.2.1:1234")
+	if code := c.postToken(m, "/form", token, "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("first use: got %d; want 200", code)
+	}
+	if code := c.postToken(m, "/form", token, "192.0.2.1:1234"); code != http.StatusBadRequest {
+		t.Errorf("replay: got %d; want 400", code)
+	}
+	if code := c.postToken(m, "/form", c.formToken(m, "/form", "192.0.2.1:1234"), "192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("new token: got %d; want 200", code)
+	}
+}
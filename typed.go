@@ -0,0 +1,65 @@
+package secure
+
+import (
+	"context"
+	"net/http"
+)
+
+/*
+A TypedManager is a Manager for authentication data of type T. Its LogIn(),
+Update(), and Authentication() methods take and return T instead of
+interface{}, so that a mismatch of the record type is a compile error.
+
+The other Manager methods (Handle, IfHandle, LogOut, NewFormToken, ...) are
+available through the embedded Manager.
+*/
+type TypedManager[T any] struct {
+	*Manager
+}
+
+/*
+NewTyped creates a TypedManager. T must be a concrete type, not an interface
+type like any; otherwise, the error is ErrRecordType.
+
+'validateFunc' is the ValidateCookie function for T: it gets the authentication
+data from the cookie, and returns the fresh data, and whether the old data was
+good enough to keep the current cookie.
+
+The other arguments are the same as for Configure().
+*/
+func NewTyped[T any](ctx context.Context, dbImpl DB, validateFunc func(src T) (dst T, valid bool), opt_config ...*Config) (*TypedManager[T], error) {
+	var record T
+	validate := func(src interface{}) (interface{}, bool) {
+		if record, ok := src.(T); ok {
+			return validateFunc(record)
+		}
+		return src, false
+	}
+	m, err := New(ctx, record, dbImpl, validate, opt_config...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedManager[T]{m}, nil
+}
+
+// LogIn calls Manager.LogIn.
+func (t *TypedManager[T]) LogIn(w http.ResponseWriter, r *http.Request, record T, opts ...LogInOption) error {
+	return t.Manager.LogIn(w, r, record, opts...)
+}
+
+// Update calls Manager.Update.
+func (t *TypedManager[T]) Update(w http.ResponseWriter, r *http.Request, record T, opts ...UpdateOption) error {
+	return t.Manager.Update(w, r, record, opts...)
+}
+
+/*
+Authentication returns the record that was stored in the cookie on LogIn(), and
+whether the client is authenticated.
+
+Call from a Handle wrapped in Handle or IfHandle, or from a handler wrapped in
+RequireAuthentication or OptionalAuthentication.
+*/
+func (t *TypedManager[T]) Authentication(r *http.Request) (record T, ok bool) {
+	record, ok = t.Manager.Authentication(r).(T)
+	return
+}
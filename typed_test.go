@@ -0,0 +1,57 @@
+package secure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewTypedInterface(t *testing.T) {
+	if _, err := NewTyped(context.Background(), &memDB{}, func(src any) (any, bool) { return src, true }); !errors.Is(err, ErrRecordType) {
+		t.Errorf("got %v; want ErrRecordType", err)
+	}
+}
+
+func TestTypedManager(t *testing.T) {
+	config := defaultConfig()
+	config.Session.ValidateTimeOut = time.Nanosecond
+	m, err := NewTyped(context.Background(), &memDB{}, func(src testRecord) (testRecord, bool) {
+		return testRecord{src.Name + "!"}, src.Name != "mallory"
+	}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	var (
+		record testRecord
+		ok     bool
+	)
+	handler := m.OptionalAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record, ok = m.Authentication(r)
+	}))
+	alice := newClient()
+	alice.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.LogIn(w, r, testRecord{"alice"}); err != nil {
+			t.Error(err)
+		}
+	}), newRequest("POST", "/session", nil))
+	time.Sleep(time.Millisecond)
+	alice.do(handler, newRequest("GET", "/", nil))
+	if !ok || record != (testRecord{"alice!"}) {
+		t.Errorf("got %v, %v; want the validated record", record, ok)
+	}
+
+	mallory := newClient()
+	mallory.do(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.LogIn(w, r, testRecord{"mallory"}); err != nil {
+			t.Error(err)
+		}
+	}), newRequest("POST", "/session", nil))
+	time.Sleep(time.Millisecond)
+	mallory.do(handler, newRequest("GET", "/", nil))
+	if ok {
+		t.Error("invalidated record accepted")
+	}
+}